@@ -0,0 +1,179 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradydean/go-todo-api/internal/pkg/cookies"
+)
+
+// Config holds the settings read from the environment. See the README for
+// what each one does.
+type Config struct {
+	DatabaseURL   string
+	Auth0Domain   string
+	Auth0Audience string
+
+	Debug              bool
+	CookieSameSite     http.SameSite
+	MaxQueryLength     int
+	StatementTimeout   time.Duration
+	ShutdownTimeout    time.Duration
+	QueryBudget        int64
+	ListCacheSize      int
+	PutUpsert          bool
+	DeleteReturns200   bool
+	MaxDependencyDepth int64
+	MaxStorageBytes    int64
+	OTLPEndpoint       string
+	RateLimit          float64
+	RateLimitBurst     int
+}
+
+// Load reads the configuration from the environment. It fails when a
+// required variable is empty, naming every one that is missing, or when an
+// optional one can't be parsed or is out of range.
+func Load() (Config, error) {
+	cfg := Config{
+		DatabaseURL:      os.Getenv("DATABASE_URL"),
+		Auth0Domain:      os.Getenv("AUTH0_DOMAIN"),
+		Auth0Audience:    os.Getenv("AUTH0_AUDIENCE"),
+		Debug:            os.Getenv("DEBUG") == "true",
+		MaxQueryLength:   2048,
+		QueryBudget:      10,
+		ShutdownTimeout:  10 * time.Second,
+		PutUpsert:        os.Getenv("PUT_UPSERT") == "true",
+		DeleteReturns200: os.Getenv("DELETE_RETURNS_200") == "true",
+		OTLPEndpoint:     os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+	}
+
+	var missing []string
+
+	for name, value := range map[string]string{
+		"DATABASE_URL":   cfg.DatabaseURL,
+		"AUTH0_DOMAIN":   cfg.Auth0Domain,
+		"AUTH0_AUDIENCE": cfg.Auth0Audience,
+	} {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		return Config{}, fmt.Errorf("missing required environment variables: %s", strings.Join(missing, ", "))
+	}
+
+	var err error
+
+	if cfg.CookieSameSite, err = cookies.ParseSameSite(os.Getenv("COOKIE_SAMESITE")); err != nil {
+		return Config{}, fmt.Errorf("invalid COOKIE_SAMESITE: %w", err)
+	}
+
+	if value := os.Getenv("MAX_QUERY_LENGTH"); value != "" {
+		if cfg.MaxQueryLength, err = strconv.Atoi(value); err != nil {
+			return Config{}, fmt.Errorf("invalid MAX_QUERY_LENGTH: %w", err)
+		}
+
+		if cfg.MaxQueryLength < 0 {
+			return Config{}, errors.New("invalid MAX_QUERY_LENGTH: must not be negative")
+		}
+	}
+
+	if value := os.Getenv("STATEMENT_TIMEOUT"); value != "" {
+		if cfg.StatementTimeout, err = time.ParseDuration(value); err != nil {
+			return Config{}, fmt.Errorf("invalid STATEMENT_TIMEOUT: %w", err)
+		}
+
+		if cfg.StatementTimeout < 0 {
+			return Config{}, errors.New("invalid STATEMENT_TIMEOUT: must not be negative")
+		}
+	}
+
+	if value := os.Getenv("SHUTDOWN_TIMEOUT"); value != "" {
+		if cfg.ShutdownTimeout, err = time.ParseDuration(value); err != nil {
+			return Config{}, fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+		}
+
+		if cfg.ShutdownTimeout < 0 {
+			return Config{}, errors.New("invalid SHUTDOWN_TIMEOUT: must not be negative")
+		}
+	}
+
+	if value := os.Getenv("QUERY_BUDGET"); value != "" {
+		if cfg.QueryBudget, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return Config{}, fmt.Errorf("invalid QUERY_BUDGET: %w", err)
+		}
+
+		if cfg.QueryBudget < 0 {
+			return Config{}, errors.New("invalid QUERY_BUDGET: must not be negative")
+		}
+	}
+
+	if value := os.Getenv("LIST_CACHE_SIZE"); value != "" {
+		if cfg.ListCacheSize, err = strconv.Atoi(value); err != nil {
+			return Config{}, fmt.Errorf("invalid LIST_CACHE_SIZE: %w", err)
+		}
+
+		if cfg.ListCacheSize < 0 {
+			return Config{}, errors.New("invalid LIST_CACHE_SIZE: must not be negative")
+		}
+	}
+
+	if value := os.Getenv("MAX_DEPENDENCY_DEPTH"); value != "" {
+		if cfg.MaxDependencyDepth, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return Config{}, fmt.Errorf("invalid MAX_DEPENDENCY_DEPTH: %w", err)
+		}
+
+		if cfg.MaxDependencyDepth < 0 {
+			return Config{}, errors.New("invalid MAX_DEPENDENCY_DEPTH: must not be negative")
+		}
+	}
+
+	if value := os.Getenv("MAX_STORAGE_BYTES"); value != "" {
+		if cfg.MaxStorageBytes, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return Config{}, fmt.Errorf("invalid MAX_STORAGE_BYTES: %w", err)
+		}
+
+		if cfg.MaxStorageBytes < 0 {
+			return Config{}, errors.New("invalid MAX_STORAGE_BYTES: must not be negative")
+		}
+	}
+
+	if value := os.Getenv("RATE_LIMIT"); value != "" {
+		if cfg.RateLimit, err = strconv.ParseFloat(value, 64); err != nil {
+			return Config{}, fmt.Errorf("invalid RATE_LIMIT: %w", err)
+		}
+
+		if math.IsNaN(cfg.RateLimit) || math.IsInf(cfg.RateLimit, 0) {
+			return Config{}, errors.New("invalid RATE_LIMIT: must be a finite number")
+		}
+
+		if cfg.RateLimit < 0 {
+			return Config{}, errors.New("invalid RATE_LIMIT: must not be negative")
+		}
+	}
+
+	// A burst below one would reject every request, so without a burst a
+	// fractional rate still lets one request through.
+	cfg.RateLimitBurst = int(math.Ceil(cfg.RateLimit))
+
+	if value := os.Getenv("RATE_LIMIT_BURST"); value != "" {
+		if cfg.RateLimitBurst, err = strconv.Atoi(value); err != nil {
+			return Config{}, fmt.Errorf("invalid RATE_LIMIT_BURST: %w", err)
+		}
+
+		if cfg.RateLimitBurst < 1 {
+			return Config{}, errors.New("invalid RATE_LIMIT_BURST: must be at least 1")
+		}
+	}
+
+	return cfg, nil
+}
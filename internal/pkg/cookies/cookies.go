@@ -0,0 +1,22 @@
+package cookies
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ParseSameSite parses a SameSite mode, one of "strict", "lax" or "none". An
+// empty mode is "lax".
+func ParseSameSite(mode string) (http.SameSite, error) {
+	switch strings.ToLower(mode) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("unknown SameSite mode %q", mode)
+	}
+}
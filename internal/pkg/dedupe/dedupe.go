@@ -0,0 +1,74 @@
+package dedupe
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrAborted is returned to calls waiting on one that panicked.
+var ErrAborted = errors.New("dedupe: call did not return")
+
+type call[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// Group collapses calls for the same key into one. A call made while another
+// for its key is running, or within window of it finishing successfully,
+// gets that call's result instead of running again. Calls are only collapsed
+// within a process, so separate instances behind a load balancer each run
+// their own.
+type Group[K comparable, V any] struct {
+	mu     sync.Mutex
+	window time.Duration
+	calls  map[K]*call[V]
+}
+
+func New[K comparable, V any](window time.Duration) *Group[K, V] {
+	return &Group[K, V]{
+		window: window,
+		calls:  make(map[K]*call[V]),
+	}
+}
+
+// Do runs fn for key, unless a call for key is running or finished within the
+// window, in which case it waits for that call and returns its result.
+// shared reports whether the result came from another call. A failed call
+// is forgotten as soon as it returns so it can be retried.
+func (g *Group[K, V]) Do(key K, fn func() (V, error)) (value V, shared bool, err error) {
+	g.mu.Lock()
+
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		<-c.done
+		return c.value, true, c.err
+	}
+
+	c := &call[V]{done: make(chan struct{}), err: ErrAborted}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		if c.err != nil {
+			g.forget(key, c)
+		} else {
+			time.AfterFunc(g.window, func() { g.forget(key, c) })
+		}
+
+		close(c.done)
+	}()
+
+	c.value, c.err = fn()
+	return c.value, false, c.err
+}
+
+func (g *Group[K, V]) forget(key K, c *call[V]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.calls[key] == c {
+		delete(g.calls, key)
+	}
+}
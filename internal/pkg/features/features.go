@@ -0,0 +1,53 @@
+package features
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const envPrefix = "FEATURE_"
+
+// Flags holds which optional features are enabled for this deployment.
+type Flags map[string]bool
+
+// Load starts from defaults and applies any FEATURE_<NAME>=true|false
+// environment variables on top, e.g. FEATURE_EXPORT=false disables "export".
+// A name that isn't in defaults is an error, so a misspelt flag doesn't
+// silently leave a feature in its default state.
+func Load(defaults map[string]bool) (Flags, error) {
+	flags := make(Flags, len(defaults))
+
+	for name, enabled := range defaults {
+		flags[name] = enabled
+	}
+
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+
+		if !strings.HasPrefix(key, envPrefix) {
+			continue
+		}
+
+		name := strings.ToLower(strings.TrimPrefix(key, envPrefix))
+
+		if _, ok := defaults[name]; !ok {
+			return nil, fmt.Errorf("unknown feature %s", key)
+		}
+
+		enabled, err := strconv.ParseBool(value)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+
+		flags[name] = enabled
+	}
+
+	return flags, nil
+}
+
+func (f Flags) Enabled(name string) bool {
+	return f[name]
+}
@@ -0,0 +1,259 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/bradydean/go-todo-api/internal/pkg/problem"
+	"github.com/bradydean/go-todo-api/internal/pkg/requestlog"
+	todo "github.com/bradydean/go-todo-api/internal/pkg/todo_api/todo/table"
+	pg "github.com/go-jet/jet/v2/postgres"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+	HeaderReplayed       = "Idempotent-Replayed"
+
+	// Keys are kept for a day, after that a retry runs the request again.
+	ttlHours     = 24
+	maxKeyLength = 255
+)
+
+var (
+	ErrKeyTooLong = problem.New(
+		http.StatusBadRequest,
+		fmt.Sprintf("Idempotency-Key must be at most %d characters", maxKeyLength),
+	)
+
+	ErrKeyReused = problem.New(
+		http.StatusUnprocessableEntity,
+		"Idempotency-Key has already been used for a different request",
+	)
+
+	ErrKeyInProgress = problem.New(
+		http.StatusConflict,
+		"A request with this Idempotency-Key is still in progress",
+	)
+)
+
+// record is a claimed key. Status is NULL until the request that claimed it
+// has finished.
+type record struct {
+	Method      string  `db:"idempotency_keys.method"`
+	Path        string  `db:"idempotency_keys.path"`
+	RequestHash *string `db:"idempotency_keys.request_hash"`
+	Status      *int32  `db:"idempotency_keys.status"`
+	ContentType *string `db:"idempotency_keys.content_type"`
+	Body        *string `db:"idempotency_keys.body"`
+}
+
+// recorder keeps a copy of the response body as it is written.
+type recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func expired() pg.BoolExpression {
+	return todo.IdempotencyKeys.CreatedAt.LT(pg.NOW().SUB(pg.INTERVAL(ttlHours, pg.HOUR)))
+}
+
+func keyCondition(userID, key string) pg.BoolExpression {
+	return todo.IdempotencyKeys.UserID.EQ(pg.String(userID)).
+		AND(todo.IdempotencyKeys.Key.EQ(pg.String(key)))
+}
+
+// claim records key as pending for this request. ok is false when the key
+// is already held, by a request that is in progress or one that finished
+// within the last day. An expired key is taken over.
+func claim(ctx context.Context, db *pgxpool.Pool, userID, key, hash string, req *http.Request) (ok bool, err error) {
+	query, args := todo.IdempotencyKeys.
+		INSERT(
+			todo.IdempotencyKeys.UserID,
+			todo.IdempotencyKeys.Key,
+			todo.IdempotencyKeys.Method,
+			todo.IdempotencyKeys.Path,
+			todo.IdempotencyKeys.RequestHash,
+		).
+		VALUES(userID, key, req.Method, req.URL.Path, hash).
+		ON_CONFLICT(todo.IdempotencyKeys.UserID, todo.IdempotencyKeys.Key).
+		DO_UPDATE(
+			pg.SET(
+				todo.IdempotencyKeys.Method.SET(todo.IdempotencyKeys.EXCLUDED.Method),
+				todo.IdempotencyKeys.Path.SET(todo.IdempotencyKeys.EXCLUDED.Path),
+				todo.IdempotencyKeys.RequestHash.SET(todo.IdempotencyKeys.EXCLUDED.RequestHash),
+				todo.IdempotencyKeys.Status.SET(pg.IntExp(pg.NULL)),
+				todo.IdempotencyKeys.ContentType.SET(pg.StringExp(pg.NULL)),
+				todo.IdempotencyKeys.Body.SET(pg.StringExp(pg.NULL)),
+				todo.IdempotencyKeys.CreatedAt.SET(pg.NOW()),
+			).WHERE(expired()),
+		).
+		Sql()
+
+	tag, err := db.Exec(ctx, query, args...)
+
+	if err != nil {
+		return false, err
+	}
+
+	return tag.RowsAffected() == 1, nil
+}
+
+// Middleware makes POST requests that carry an Idempotency-Key header safe
+// to retry. The key is claimed before the handler runs, so a concurrent
+// retry gets a 409 instead of running it a second time. Once the handler
+// succeeds its response is stored and returned again for any repeat of the
+// key with the same body, while reusing the key for a different method, path
+// or body is a 422. Error responses aren't stored, the claim is released so
+// a failed request can be retried with the same key. It must run after the
+// user id has been set.
+func Middleware(db *pgxpool.Pool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			key := req.Header.Get(HeaderIdempotencyKey)
+
+			if req.Method != http.MethodPost || key == "" {
+				return next(c)
+			}
+
+			if len(key) > maxKeyLength {
+				return ErrKeyTooLong
+			}
+
+			userID := c.Get("userID").(string)
+			ctx := req.Context()
+
+			body, err := io.ReadAll(req.Body)
+
+			if err != nil {
+				return err
+			}
+
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(body)
+			hash := hex.EncodeToString(sum[:])
+
+			claimed, err := claim(ctx, db, userID, key, hash, req)
+
+			if err != nil {
+				return fmt.Errorf("claiming idempotency key: %w", err)
+			}
+
+			if !claimed {
+				query, args := pg.SELECT(
+					todo.IdempotencyKeys.Method,
+					todo.IdempotencyKeys.Path,
+					todo.IdempotencyKeys.RequestHash,
+					todo.IdempotencyKeys.Status,
+					todo.IdempotencyKeys.ContentType,
+					todo.IdempotencyKeys.Body,
+				).
+					FROM(todo.IdempotencyKeys).
+					WHERE(keyCondition(userID, key)).
+					Sql()
+
+				rows, _ := db.Query(ctx, query, args...)
+				stored, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[record])
+
+				// The claim was released between the insert and the select.
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrKeyInProgress
+				}
+
+				if err != nil {
+					return fmt.Errorf("fetching idempotency key: %w", err)
+				}
+
+				if stored.Method != req.Method || stored.Path != req.URL.Path {
+					return ErrKeyReused
+				}
+
+				if stored.RequestHash != nil && *stored.RequestHash != hash {
+					return ErrKeyReused
+				}
+
+				if stored.Status == nil {
+					c.Response().Header().Set("Retry-After", "1")
+					return ErrKeyInProgress
+				}
+
+				c.Response().Header().Set(HeaderReplayed, "true")
+				return c.Blob(int(*stored.Status), *stored.ContentType, []byte(*stored.Body))
+			}
+
+			completed := false
+
+			// A request that fails, or panics, gives the key back. The
+			// request context may already be done, so it isn't used here.
+			defer func() {
+				if completed {
+					return
+				}
+
+				query, args := todo.IdempotencyKeys.
+					DELETE().
+					WHERE(keyCondition(userID, key).AND(todo.IdempotencyKeys.Status.IS_NULL())).
+					Sql()
+
+				if _, err := db.Exec(context.Background(), query, args...); err != nil {
+					requestlog.Logger(c).Error("Error releasing idempotency key", "err", err)
+				}
+			}()
+
+			rec := &recorder{ResponseWriter: c.Response().Writer}
+			c.Response().Writer = rec
+
+			if err := next(c); err != nil {
+				return err
+			}
+
+			status := c.Response().Status
+
+			if status < 200 || status >= 300 {
+				return nil
+			}
+
+			// If this fails the key stays claimed, so a retry gets a 409
+			// rather than creating the resource twice.
+			completed = true
+
+			query, args := todo.IdempotencyKeys.
+				UPDATE().
+				SET(
+					todo.IdempotencyKeys.Status.SET(pg.Int(int64(status))),
+					todo.IdempotencyKeys.ContentType.SET(pg.String(c.Response().Header().Get(echo.HeaderContentType))),
+					todo.IdempotencyKeys.Body.SET(pg.String(rec.body.String())),
+				).
+				WHERE(keyCondition(userID, key)).
+				Sql()
+
+			if _, err := db.Exec(context.Background(), query, args...); err != nil {
+				return fmt.Errorf("storing idempotency key: %w", err)
+			}
+
+			return nil
+		}
+	}
+}
+
+// Purge deletes expired keys. They are already ignored by Middleware, this
+// only keeps the table from growing.
+func Purge(ctx context.Context, db *pgxpool.Pool) error {
+	query, args := todo.IdempotencyKeys.DELETE().WHERE(expired()).Sql()
+	_, err := db.Exec(ctx, query, args...)
+	return err
+}
@@ -0,0 +1,73 @@
+package jsonapi
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const MIMEType = "application/vnd.api+json"
+
+type ResourceIdentifier struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type Relationship struct {
+	Data ResourceIdentifier `json:"data"`
+}
+
+type Resource struct {
+	Type          string                  `json:"type"`
+	ID            string                  `json:"id"`
+	Attributes    any                     `json:"attributes"`
+	Relationships map[string]Relationship `json:"relationships,omitempty"`
+}
+
+type Document struct {
+	Data any `json:"data"`
+}
+
+// Resourcer is implemented by response bodies that have a JSON:API
+// representation.
+type Resourcer interface {
+	Resource(c echo.Context) Resource
+}
+
+// Accepted reports whether the client asked for JSON:API responses.
+func Accepted(c echo.Context) bool {
+	return strings.Contains(c.Request().Header.Get(echo.HeaderAccept), MIMEType)
+}
+
+// Serializer wraps another serializer. When the client accepts JSON:API, a
+// Resourcer or a slice of them is written as a JSON:API document, everything
+// else is left to the wrapped serializer, as is decoding.
+type Serializer struct {
+	echo.JSONSerializer
+}
+
+func (s Serializer) Serialize(c echo.Context, i any, indent string) error {
+	if !Accepted(c) {
+		return s.JSONSerializer.Serialize(c, i, indent)
+	}
+
+	var data any
+
+	if resourcer, ok := i.(Resourcer); ok {
+		data = resourcer.Resource(c)
+	} else if v := reflect.ValueOf(i); v.Kind() == reflect.Slice && v.Type().Elem().Implements(reflect.TypeFor[Resourcer]()) {
+		resources := make([]Resource, 0, v.Len())
+
+		for n := range v.Len() {
+			resources = append(resources, v.Index(n).Interface().(Resourcer).Resource(c))
+		}
+
+		data = resources
+	} else {
+		return s.JSONSerializer.Serialize(c, i, indent)
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, MIMEType)
+	return s.JSONSerializer.Serialize(c, Document{Data: data}, indent)
+}
@@ -1,18 +1,32 @@
 package jwtmiddleware
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/labstack/echo/v4"
+	"net/http"
 	"net/url"
-	"os"
 	"time"
 
+	"github.com/bradydean/go-todo-api/internal/pkg/config"
+	"github.com/bradydean/go-todo-api/internal/pkg/problem"
+
 	jwtmiddleware "github.com/auth0/go-jwt-middleware/v2"
 	"github.com/auth0/go-jwt-middleware/v2/jwks"
 	"github.com/auth0/go-jwt-middleware/v2/validator"
 )
 
-func New() (echo.MiddlewareFunc, error) {
-	var issuerURL, err = url.Parse("https://" + os.Getenv("AUTH0_DOMAIN") + "/")
+// CustomClaims holds every claim in the token so they can be inspected
+// without declaring each one up front.
+type CustomClaims map[string]any
+
+func (c *CustomClaims) Validate(context.Context) error {
+	return nil
+}
+
+func New(cfg config.Config) (echo.MiddlewareFunc, error) {
+	var issuerURL, err = url.Parse("https://" + cfg.Auth0Domain + "/")
 
 	if err != nil {
 		return nil, err
@@ -24,20 +38,55 @@ func New() (echo.MiddlewareFunc, error) {
 		provider.KeyFunc,
 		validator.RS256,
 		issuerURL.String(),
-		[]string{os.Getenv("AUTH0_AUDIENCE")},
+		[]string{cfg.Auth0Audience},
+		validator.WithCustomClaims(func() validator.CustomClaims {
+			return &CustomClaims{}
+		}),
 	)
 
 	if err != nil {
 		return nil, err
 	}
 
-	jwtMiddleware := jwtmiddleware.New(validator.ValidateToken)
+	jwtMiddleware := jwtmiddleware.New(validator.ValidateToken, jwtmiddleware.WithErrorHandler(errorHandler))
 	return echo.WrapMiddleware(jwtMiddleware.CheckJWT), nil
 }
 
+// errorHandler writes a 401 as problem details, like every other error the
+// API returns. The auth0 default responds 400 to a missing token and with
+// its own JSON body.
+func errorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	detail := "The bearer token is invalid or expired"
+
+	if errors.Is(err, jwtmiddleware.ErrJWTMissing) {
+		detail = "A bearer token is required"
+	}
+
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set(echo.HeaderContentType, problem.MIMEType)
+	w.WriteHeader(http.StatusUnauthorized)
+
+	json.NewEncoder(w).Encode(problem.Details{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusUnauthorized),
+		Status: http.StatusUnauthorized,
+		Detail: detail,
+	})
+}
+
+func Claims(c echo.Context) *validator.ValidatedClaims {
+	return c.Request().Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+}
+
 func UserID(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		validatedClaims := c.Request().Context().Value(jwtmiddleware.ContextKey{}).(*validator.ValidatedClaims)
+		validatedClaims := Claims(c)
 		c.Set("userID", validatedClaims.RegisteredClaims.Subject)
 		return next(c)
 	}
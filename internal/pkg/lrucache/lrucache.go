@@ -0,0 +1,129 @@
+package lrucache
+
+import (
+	"container/list"
+	"sync"
+)
+
+type entry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// Cache is a fixed size, least recently used cache safe for concurrent use.
+// A Cache with a size of zero or less never stores anything.
+type Cache[K comparable, V any] struct {
+	mu       sync.Mutex
+	size     int
+	order    *list.List
+	elements map[K]*list.Element
+
+	// clock is bumped by every Remove and removed holds when each key was
+	// last removed, so AddIfCurrent can tell a value read before a Remove.
+	// Once removed grows past the size of the cache it is cleared, and
+	// anything read before then is treated as stale.
+	clock   uint64
+	floor   uint64
+	removed map[K]uint64
+}
+
+func New[K comparable, V any](size int) *Cache[K, V] {
+	return &Cache[K, V]{
+		size:     size,
+		order:    list.New(),
+		elements: make(map[K]*list.Element),
+		removed:  make(map[K]uint64),
+	}
+}
+
+// Generation returns a token to pass to AddIfCurrent. Take it before
+// reading the value that will be cached.
+func (c *Cache[K, V]) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.clock
+}
+
+// AddIfCurrent is like Add, but does nothing when key has been removed
+// since generation was taken, as the value may be older than the write that
+// removed it.
+func (c *Cache[K, V]) AddIfCurrent(key K, value V, generation uint64) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation < c.floor || c.removed[key] > generation {
+		return
+	}
+
+	c.add(key, value)
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.elements[key]
+
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	c.order.MoveToFront(element)
+	return element.Value.(*entry[K, V]).value, true
+}
+
+func (c *Cache[K, V]) Add(key K, value V) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(key, value)
+}
+
+func (c *Cache[K, V]) add(key K, value V) {
+	if element, ok := c.elements[key]; ok {
+		element.Value.(*entry[K, V]).value = value
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.elements[key] = c.order.PushFront(&entry[K, V]{key: key, value: value})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.elements, oldest.Value.(*entry[K, V]).key)
+	}
+}
+
+func (c *Cache[K, V]) Remove(key K) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.clock++
+
+	if len(c.removed) >= c.size {
+		clear(c.removed)
+		c.floor = c.clock
+	}
+
+	c.removed[key] = c.clock
+
+	if element, ok := c.elements[key]; ok {
+		c.order.Remove(element)
+		delete(c.elements, key)
+	}
+}
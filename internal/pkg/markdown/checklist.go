@@ -0,0 +1,43 @@
+package markdown
+
+import (
+	"strings"
+)
+
+type ChecklistItem struct {
+	Content string
+	Checked bool
+}
+
+// Checklist writes a markdown document with title as a heading, description
+// as a paragraph and each item as a task list entry.
+func Checklist(title, description string, items []ChecklistItem) string {
+	var b strings.Builder
+
+	b.WriteString("# ")
+	b.WriteString(singleLine(title))
+	b.WriteString("\n\n")
+
+	if description != "" {
+		b.WriteString(description)
+		b.WriteString("\n\n")
+	}
+
+	for _, item := range items {
+		if item.Checked {
+			b.WriteString("- [x] ")
+		} else {
+			b.WriteString("- [ ] ")
+		}
+		b.WriteString(singleLine(item.Content))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// singleLine keeps multi-line text from breaking out of a heading or list
+// entry.
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,23 @@
+package markdown
+
+import (
+	"bytes"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+)
+
+var policy = bluemonday.UGCPolicy()
+
+// RenderHTML renders markdown source to HTML and sanitizes the result so it
+// is safe to embed in a page. Scripts, event handler attributes and unsafe
+// URLs are stripped.
+func RenderHTML(source string) (string, error) {
+	var buf bytes.Buffer
+
+	if err := goldmark.Convert([]byte(source), &buf); err != nil {
+		return "", err
+	}
+
+	return policy.Sanitize(buf.String()), nil
+}
@@ -0,0 +1,88 @@
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/bradydean/go-todo-api/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+)
+
+const MIMEType = "application/merge-patch+json"
+
+var (
+	ErrEmptyPatch = problem.New(
+		http.StatusBadRequest,
+		"The merge patch body is empty",
+	)
+
+	ErrInvalidJSON = problem.New(
+		http.StatusBadRequest,
+		"The merge patch is not valid JSON",
+	)
+
+	ErrNotObject = problem.New(
+		http.StatusBadRequest,
+		"The merge patch must be a JSON object",
+	)
+)
+
+// Field is a member of an RFC 7386 JSON Merge Patch. Unlike a pointer it
+// tells a member that was left out apart from one that was set to null.
+type Field[T any] struct {
+	Present bool
+	Null    bool
+	Value   T
+}
+
+// Set reports whether the patch gave the member a value other than null.
+func (f Field[T]) Set() bool {
+	return f.Present && !f.Null
+}
+
+func (f *Field[T]) UnmarshalJSON(data []byte) error {
+	f.Present = true
+
+	if string(data) == "null" {
+		f.Null = true
+		return nil
+	}
+
+	return json.Unmarshal(data, &f.Value)
+}
+
+// Bind decodes the request body into i. Bodies sent as
+// application/merge-patch+json are accepted alongside application/json,
+// which echo's binder would reject. Those must hold a JSON object, a patch
+// that replaces the whole resource isn't supported.
+func Bind(c echo.Context, i any) error {
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), MIMEType) {
+		return c.Bind(i)
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+
+	if err != nil {
+		return err
+	}
+
+	body = bytes.TrimSpace(body)
+
+	if len(body) == 0 {
+		return ErrEmptyPatch
+	}
+
+	if !json.Valid(body) {
+		return ErrInvalidJSON
+	}
+
+	if body[0] != '{' {
+		return ErrNotObject
+	}
+
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+	return c.Echo().JSONSerializer.Deserialize(c, i)
+}
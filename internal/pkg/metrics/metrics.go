@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	duration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+func init() {
+	prometheus.MustRegister(requests, duration)
+}
+
+// Middleware records the count and latency of every request. Requests are
+// labelled with the route pattern rather than the path so ids don't create a
+// new series each.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+
+		// The error handler hasn't written the response yet, so take the
+		// status it will use from the error.
+		if err != nil {
+			var he *echo.HTTPError
+			var be *echo.BindingError
+
+			if errors.As(err, &be) {
+				status = be.Code
+			} else if errors.As(err, &he) {
+				status = he.Code
+			} else {
+				status = http.StatusInternalServerError
+			}
+		}
+
+		labels := prometheus.Labels{
+			"route":  c.Path(),
+			"method": c.Request().Method,
+			"status": strconv.Itoa(status),
+		}
+
+		requests.With(labels).Inc()
+		duration.With(labels).Observe(time.Since(start).Seconds())
+
+		return err
+	}
+}
+
+// RegisterPool exports the connection counts of db, read from db.Stat() on
+// every scrape.
+func RegisterPool(db *pgxpool.Pool) {
+	gauge := func(name, help string, value func(*pgxpool.Stat) int32) prometheus.GaugeFunc {
+		return prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: name,
+			Help: help,
+		}, func() float64 {
+			return float64(value(db.Stat()))
+		})
+	}
+
+	prometheus.MustRegister(
+		gauge("pgxpool_acquired_connections", "Number of connections currently in use.", (*pgxpool.Stat).AcquiredConns),
+		gauge("pgxpool_idle_connections", "Number of idle connections in the pool.", (*pgxpool.Stat).IdleConns),
+		gauge("pgxpool_total_connections", "Total number of connections in the pool.", (*pgxpool.Stat).TotalConns),
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func Handler() echo.HandlerFunc {
+	return echo.WrapHandler(promhttp.Handler())
+}
@@ -0,0 +1,117 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+
+	"github.com/bradydean/go-todo-api/internal/pkg/requestlog"
+	"github.com/labstack/echo/v4"
+)
+
+const MIMEType = "application/problem+json"
+
+// Details is an RFC 7807 problem details object. Extensions are written as
+// additional top level members.
+type Details struct {
+	Type       string         `json:"type"`
+	Title      string         `json:"title"`
+	Status     int            `json:"status"`
+	Detail     string         `json:"detail,omitempty"`
+	Extensions map[string]any `json:"-"`
+}
+
+func (d Details) MarshalJSON() ([]byte, error) {
+	members := make(map[string]any, len(d.Extensions)+4)
+	maps.Copy(members, d.Extensions)
+	members["type"] = d.Type
+	members["title"] = d.Title
+	members["status"] = d.Status
+
+	if d.Detail != "" {
+		members["detail"] = d.Detail
+	}
+
+	return json.Marshal(members)
+}
+
+// New returns an error whose body is a problem details object for status.
+func New(status int, detail string) *echo.HTTPError {
+	return WithExtensions(status, detail, nil)
+}
+
+// WithExtensions is like New but adds extension members to the body.
+func WithExtensions(status int, detail string, extensions map[string]any) *echo.HTTPError {
+	return echo.NewHTTPError(status, Details{
+		Type:       "about:blank",
+		Title:      http.StatusText(status),
+		Status:     status,
+		Detail:     detail,
+		Extensions: extensions,
+	})
+}
+
+// Extend returns a copy of err, which must come from New or WithExtensions,
+// with extensions added to its body.
+func Extend(err *echo.HTTPError, extensions map[string]any) *echo.HTTPError {
+	details, ok := err.Message.(Details)
+
+	if !ok {
+		return WithExtensions(err.Code, "", extensions)
+	}
+
+	merged := make(map[string]any, len(details.Extensions)+len(extensions))
+	maps.Copy(merged, details.Extensions)
+	maps.Copy(merged, extensions)
+	details.Extensions = merged
+
+	return echo.NewHTTPError(err.Code, details)
+}
+
+// Handler is an echo.HTTPErrorHandler that writes every error as
+// application/problem+json. Errors that aren't echo errors become a 500
+// without a detail so internals aren't leaked.
+func Handler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var he *echo.HTTPError
+	var be *echo.BindingError
+
+	if errors.As(err, &be) {
+		he = be.HTTPError
+	} else if !errors.As(err, &he) {
+		he = echo.ErrInternalServerError
+	}
+
+	if internal, ok := he.Internal.(*echo.HTTPError); ok {
+		he = internal
+	}
+
+	details, ok := he.Message.(Details)
+
+	if !ok {
+		details = Details{
+			Type:   "about:blank",
+			Title:  http.StatusText(he.Code),
+			Status: he.Code,
+		}
+
+		if message, ok := he.Message.(string); ok && message != details.Title {
+			details.Detail = message
+		}
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(he.Code)
+	} else {
+		c.Response().Header().Set(echo.HeaderContentType, MIMEType)
+		err = c.JSON(he.Code, details)
+	}
+
+	if err != nil {
+		requestlog.Logger(c).Error("Error writing error response", "err", err)
+	}
+}
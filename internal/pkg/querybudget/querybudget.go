@@ -0,0 +1,51 @@
+package querybudget
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/bradydean/go-todo-api/internal/pkg/requestlog"
+	"github.com/jackc/pgx/v5"
+	"github.com/labstack/echo/v4"
+)
+
+type contextKey struct{}
+
+// Tracer counts the queries issued with a context prepared by Middleware.
+type Tracer struct{}
+
+func (Tracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	if counter, ok := ctx.Value(contextKey{}).(*atomic.Int64); ok {
+		counter.Add(1)
+	}
+	return ctx
+}
+
+func (Tracer) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+// Middleware attaches a query counter to the request context and logs a
+// warning when a request issues more than budget queries. The pool must be
+// configured with Tracer for queries to be counted.
+func Middleware(budget int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			var counter atomic.Int64
+			ctx := context.WithValue(c.Request().Context(), contextKey{}, &counter)
+			c.SetRequest(c.Request().WithContext(ctx))
+
+			err := next(c)
+
+			if queries := counter.Load(); queries > budget {
+				requestlog.Logger(c).LogAttrs(ctx, slog.LevelWarn, "query budget exceeded",
+					slog.String("method", c.Request().Method),
+					slog.String("route", c.Path()),
+					slog.Int64("queries", queries),
+					slog.Int64("budget", budget),
+				)
+			}
+
+			return err
+		}
+	}
+}
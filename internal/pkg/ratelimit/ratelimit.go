@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bradydean/go-todo-api/internal/pkg/problem"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/time/rate"
+)
+
+const (
+	HeaderLimit     = "X-RateLimit-Limit"
+	HeaderRemaining = "X-RateLimit-Remaining"
+	HeaderReset     = "X-RateLimit-Reset"
+)
+
+var ErrRateLimited = problem.New(
+	http.StatusTooManyRequests,
+	"Rate limit exceeded",
+)
+
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// limiters holds a token bucket per user. A bucket that has been left alone
+// long enough to refill is the same as a new one, so it is dropped.
+type limiters struct {
+	mu          sync.Mutex
+	limit       rate.Limit
+	burst       int
+	refill      time.Duration
+	visitors    map[string]*visitor
+	lastCleanup time.Time
+}
+
+// take spends a token from userID's bucket if there is one, and returns the
+// tokens left afterwards.
+func (l *limiters) take(userID string, now time.Time) (ok bool, tokens float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) > l.refill {
+		for id, v := range l.visitors {
+			if now.Sub(v.lastSeen) > l.refill {
+				delete(l.visitors, id)
+			}
+		}
+
+		l.lastCleanup = now
+	}
+
+	v, found := l.visitors[userID]
+
+	if !found {
+		v = &visitor{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.visitors[userID] = v
+	}
+
+	v.lastSeen = now
+	ok = v.limiter.AllowN(now, 1)
+	return ok, v.limiter.TokensAt(now)
+}
+
+// Middleware limits each user to limit requests per second with bursts of up
+// to burst. Every response carries X-RateLimit-Limit, the burst,
+// X-RateLimit-Remaining, the requests that can be made right away, and
+// X-RateLimit-Reset, the seconds until the full burst is available again. A
+// request over the limit gets a 429 with a Retry-After header. It must run
+// after the user id has been set.
+func Middleware(limit float64, burst int) echo.MiddlewareFunc {
+	l := &limiters{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		refill:   time.Duration(float64(burst) / limit * float64(time.Second)),
+		visitors: make(map[string]*visitor),
+	}
+
+	seconds := func(tokens float64) string {
+		return strconv.Itoa(int(math.Ceil(tokens / limit)))
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ok, tokens := l.take(c.Get("userID").(string), time.Now())
+			header := c.Response().Header()
+
+			header.Set(HeaderLimit, strconv.Itoa(burst))
+			header.Set(HeaderRemaining, strconv.Itoa(max(int(tokens), 0)))
+			header.Set(HeaderReset, seconds(float64(burst)-tokens))
+
+			if !ok {
+				header.Set("Retry-After", seconds(1-tokens))
+				return ErrRateLimited
+			}
+
+			return next(c)
+		}
+	}
+}
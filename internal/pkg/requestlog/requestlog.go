@@ -0,0 +1,25 @@
+package requestlog
+
+import (
+	"log/slog"
+
+	"github.com/labstack/echo/v4"
+)
+
+const key = "logger"
+
+// Set makes logger the one returned by Logger for the rest of the request.
+func Set(c echo.Context, logger *slog.Logger) {
+	c.Set(key, logger)
+}
+
+// Logger returns the logger for the request, tagged with its request_id and,
+// once the request is authenticated, its user_id. It falls back to
+// slog.Default before a logger has been set.
+func Logger(c echo.Context) *slog.Logger {
+	if logger, ok := c.Get(key).(*slog.Logger); ok {
+		return logger
+	}
+
+	return slog.Default()
+}
@@ -0,0 +1,187 @@
+package strictjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	ErrTrailingData = echo.NewHTTPError(http.StatusBadRequest, "Request body must contain a single JSON value")
+
+	unmarshalerType = reflect.TypeFor[json.Unmarshaler]()
+)
+
+// Serializer is echo's default JSON serializer with a stricter Deserialize.
+type Serializer struct {
+	echo.DefaultJSONSerializer
+}
+
+// Deserialize is like echo's default but rejects members that i has no
+// field for, naming the first one found, so a misspelt field is an error
+// rather than silently ignored. Anything after the first JSON value is
+// rejected too.
+func (s Serializer) Deserialize(c echo.Context, i any) error {
+	decoder := json.NewDecoder(c.Request().Body)
+
+	var body json.RawMessage
+
+	if err := decoder.Decode(&body); err != nil {
+		return decodeError(err)
+	}
+
+	if err := decoder.Decode(&json.RawMessage{}); !errors.Is(err, io.EOF) {
+		return ErrTrailingData
+	}
+
+	if path := unknownField(body, reflect.TypeOf(i), ""); path != "" {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unknown field %q", path))
+	}
+
+	decoder = json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(i); err != nil {
+		return decodeError(err)
+	}
+
+	return nil
+}
+
+// decodeError turns the errors echo's serializer would into a 400 in the
+// same way.
+func decodeError(err error) error {
+	var ute *json.UnmarshalTypeError
+	var se *json.SyntaxError
+
+	if errors.As(err, &ute) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Unmarshal type error: expected=%v, got=%v, field=%v, offset=%v", ute.Type, ute.Value, ute.Field, ute.Offset)).SetInternal(err)
+	}
+
+	if errors.As(err, &se) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Syntax error: offset=%v, error=%v", se.Offset, se.Error())).SetInternal(err)
+	}
+
+	return err
+}
+
+// unknownField returns the path of the first member of data that t has no
+// field for, or "" if there is none. Values that don't have the shape t
+// expects are left for json.Unmarshal to report, and so are types that
+// unmarshal themselves.
+func unknownField(data []byte, t reflect.Type, path string) string {
+	if t == nil {
+		return ""
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Implements(unmarshalerType) || reflect.PointerTo(t).Implements(unmarshalerType) {
+		return ""
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var members map[string]json.RawMessage
+
+		if json.Unmarshal(data, &members) != nil {
+			return ""
+		}
+
+		fields := jsonFields(t)
+		names := make([]string, 0, len(members))
+
+		for name := range members {
+			names = append(names, name)
+		}
+
+		slices.Sort(names)
+
+		for _, name := range names {
+			member := name
+
+			if path != "" {
+				member = path + "." + name
+			}
+
+			// encoding/json matches names case-insensitively.
+			field, ok := fields[strings.ToLower(name)]
+
+			if !ok {
+				return member
+			}
+
+			if found := unknownField(members[name], field, member); found != "" {
+				return found
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		var elements []json.RawMessage
+
+		if json.Unmarshal(data, &elements) != nil {
+			return ""
+		}
+
+		for n, element := range elements {
+			if found := unknownField(element, t.Elem(), fmt.Sprintf("%s[%d]", path, n)); found != "" {
+				return found
+			}
+		}
+	case reflect.Map:
+		var members map[string]json.RawMessage
+
+		if json.Unmarshal(data, &members) != nil {
+			return ""
+		}
+
+		for name, value := range members {
+			if found := unknownField(value, t.Elem(), path+"."+name); found != "" {
+				return found
+			}
+		}
+	}
+
+	return ""
+}
+
+// jsonFields returns the type of each field encoding/json would decode into
+// for t, keyed by lowercased name. Fields of embedded structs are promoted.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+
+	for _, field := range reflect.VisibleFields(t) {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if !field.IsExported() || name == "-" {
+			continue
+		}
+
+		if embedded := field.Type; field.Anonymous && name == "" {
+			if embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+
+			if embedded.Kind() == reflect.Struct {
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fields[strings.ToLower(name)] = field.Type
+	}
+
+	return fields
+}
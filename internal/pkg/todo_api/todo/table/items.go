@@ -17,10 +17,18 @@ type itemsTable struct {
 	postgres.Table
 
 	// Columns
-	ItemID     postgres.ColumnInteger
-	ListID     postgres.ColumnInteger
-	Content    postgres.ColumnString
-	IsComplete postgres.ColumnBool
+	ItemID          postgres.ColumnInteger
+	ListID          postgres.ColumnInteger
+	Content         postgres.ColumnString
+	IsComplete      postgres.ColumnBool
+	BlockedByItemID postgres.ColumnInteger
+	CreatedAt       postgres.ColumnTimestampz
+	UpdatedAt       postgres.ColumnTimestampz
+	Position        postgres.ColumnInteger
+	DueDate         postgres.ColumnTimestampz
+	Priority        postgres.ColumnString
+	Version         postgres.ColumnInteger
+	CompletedAt     postgres.ColumnTimestampz
 
 	AllColumns     postgres.ColumnList
 	MutableColumns postgres.ColumnList
@@ -61,22 +69,38 @@ func newItemsTable(schemaName, tableName, alias string) *ItemsTable {
 
 func newItemsTableImpl(schemaName, tableName, alias string) itemsTable {
 	var (
-		ItemIDColumn     = postgres.IntegerColumn("item_id")
-		ListIDColumn     = postgres.IntegerColumn("list_id")
-		ContentColumn    = postgres.StringColumn("content")
-		IsCompleteColumn = postgres.BoolColumn("is_complete")
-		allColumns       = postgres.ColumnList{ItemIDColumn, ListIDColumn, ContentColumn, IsCompleteColumn}
-		mutableColumns   = postgres.ColumnList{ListIDColumn, ContentColumn, IsCompleteColumn}
+		ItemIDColumn          = postgres.IntegerColumn("item_id")
+		ListIDColumn          = postgres.IntegerColumn("list_id")
+		ContentColumn         = postgres.StringColumn("content")
+		IsCompleteColumn      = postgres.BoolColumn("is_complete")
+		BlockedByItemIDColumn = postgres.IntegerColumn("blocked_by_item_id")
+		CreatedAtColumn       = postgres.TimestampzColumn("created_at")
+		UpdatedAtColumn       = postgres.TimestampzColumn("updated_at")
+		PositionColumn        = postgres.IntegerColumn("position")
+		DueDateColumn         = postgres.TimestampzColumn("due_date")
+		PriorityColumn        = postgres.StringColumn("priority")
+		VersionColumn         = postgres.IntegerColumn("version")
+		CompletedAtColumn     = postgres.TimestampzColumn("completed_at")
+		allColumns            = postgres.ColumnList{ItemIDColumn, ListIDColumn, ContentColumn, IsCompleteColumn, BlockedByItemIDColumn, CreatedAtColumn, UpdatedAtColumn, PositionColumn, DueDateColumn, PriorityColumn, VersionColumn, CompletedAtColumn}
+		mutableColumns        = postgres.ColumnList{ListIDColumn, ContentColumn, IsCompleteColumn, BlockedByItemIDColumn, CreatedAtColumn, UpdatedAtColumn, PositionColumn, DueDateColumn, PriorityColumn, VersionColumn, CompletedAtColumn}
 	)
 
 	return itemsTable{
 		Table: postgres.NewTable(schemaName, tableName, alias, allColumns...),
 
 		//Columns
-		ItemID:     ItemIDColumn,
-		ListID:     ListIDColumn,
-		Content:    ContentColumn,
-		IsComplete: IsCompleteColumn,
+		ItemID:          ItemIDColumn,
+		ListID:          ListIDColumn,
+		Content:         ContentColumn,
+		IsComplete:      IsCompleteColumn,
+		BlockedByItemID: BlockedByItemIDColumn,
+		CreatedAt:       CreatedAtColumn,
+		UpdatedAt:       UpdatedAtColumn,
+		Position:        PositionColumn,
+		DueDate:         DueDateColumn,
+		Priority:        PriorityColumn,
+		Version:         VersionColumn,
+		CompletedAt:     CompletedAtColumn,
 
 		AllColumns:     allColumns,
 		MutableColumns: mutableColumns,
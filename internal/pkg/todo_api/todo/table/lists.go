@@ -21,6 +21,12 @@ type listsTable struct {
 	UserID      postgres.ColumnString
 	Title       postgres.ColumnString
 	Description postgres.ColumnString
+	IsPinned    postgres.ColumnBool
+	Category    postgres.ColumnString
+	CreatedAt   postgres.ColumnTimestampz
+	UpdatedAt   postgres.ColumnTimestampz
+	DeletedAt   postgres.ColumnTimestampz
+	Version     postgres.ColumnInteger
 
 	AllColumns     postgres.ColumnList
 	MutableColumns postgres.ColumnList
@@ -65,8 +71,14 @@ func newListsTableImpl(schemaName, tableName, alias string) listsTable {
 		UserIDColumn      = postgres.StringColumn("user_id")
 		TitleColumn       = postgres.StringColumn("title")
 		DescriptionColumn = postgres.StringColumn("description")
-		allColumns        = postgres.ColumnList{ListIDColumn, UserIDColumn, TitleColumn, DescriptionColumn}
-		mutableColumns    = postgres.ColumnList{UserIDColumn, TitleColumn, DescriptionColumn}
+		IsPinnedColumn    = postgres.BoolColumn("is_pinned")
+		CategoryColumn    = postgres.StringColumn("category")
+		CreatedAtColumn   = postgres.TimestampzColumn("created_at")
+		UpdatedAtColumn   = postgres.TimestampzColumn("updated_at")
+		DeletedAtColumn   = postgres.TimestampzColumn("deleted_at")
+		VersionColumn     = postgres.IntegerColumn("version")
+		allColumns        = postgres.ColumnList{ListIDColumn, UserIDColumn, TitleColumn, DescriptionColumn, IsPinnedColumn, CategoryColumn, CreatedAtColumn, UpdatedAtColumn, DeletedAtColumn, VersionColumn}
+		mutableColumns    = postgres.ColumnList{UserIDColumn, TitleColumn, DescriptionColumn, IsPinnedColumn, CategoryColumn, CreatedAtColumn, UpdatedAtColumn, DeletedAtColumn, VersionColumn}
 	)
 
 	return listsTable{
@@ -77,6 +89,12 @@ func newListsTableImpl(schemaName, tableName, alias string) listsTable {
 		UserID:      UserIDColumn,
 		Title:       TitleColumn,
 		Description: DescriptionColumn,
+		IsPinned:    IsPinnedColumn,
+		Category:    CategoryColumn,
+		CreatedAt:   CreatedAtColumn,
+		UpdatedAt:   UpdatedAtColumn,
+		DeletedAt:   DeletedAtColumn,
+		Version:     VersionColumn,
 
 		AllColumns:     allColumns,
 		MutableColumns: mutableColumns,
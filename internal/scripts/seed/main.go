@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+
+	_ "github.com/joho/godotenv/autoload"
+
+	todo "github.com/bradydean/go-todo-api/internal/pkg/todo_api/todo/table"
+	pg "github.com/go-jet/jet/v2/postgres"
+	"github.com/jackc/pgx/v5"
+)
+
+type seedItem struct {
+	content    string
+	isComplete bool
+}
+
+type seedList struct {
+	title       string
+	description string
+	isPinned    bool
+	category    string
+	items       []seedItem
+}
+
+var lists = []seedList{
+	{
+		title:       "Groceries",
+		description: "For the **weekend**",
+		isPinned:    true,
+		category:    "shopping",
+		items: []seedItem{
+			{content: "Milk", isComplete: true},
+			{content: "Eggs"},
+			{content: "Bread"},
+			{content: "Coffee beans"},
+		},
+	},
+	{
+		title:       "Release 1.2",
+		description: "Everything left before tagging the release",
+		category:    "work",
+		items: []seedItem{
+			{content: "Write changelog", isComplete: true},
+			{content: "Update dependencies", isComplete: true},
+			{content: "Run migrations on staging"},
+			{content: "Tag release"},
+		},
+	},
+	{
+		title:       "Home",
+		description: "",
+		category:    "personal",
+		items: []seedItem{
+			{content: "Fix the kitchen tap"},
+			{content: "Book dentist appointment"},
+		},
+	},
+	{
+		title:       "Ideas",
+		description: "Nothing here yet",
+		category:    "other",
+	},
+}
+
+// seed replaces every list and item owned by userID with the lists above.
+func seed(ctx context.Context, tx pgx.Tx, userID string) error {
+	query, args := todo.Items.
+		DELETE().
+		USING(todo.Lists).
+		WHERE(
+			todo.Items.ListID.EQ(todo.Lists.ListID).
+				AND(todo.Lists.UserID.EQ(pg.String(userID))),
+		).
+		Sql()
+
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		return err
+	}
+
+	query, args = todo.Lists.
+		DELETE().
+		WHERE(todo.Lists.UserID.EQ(pg.String(userID))).
+		Sql()
+
+	if _, err := tx.Exec(ctx, query, args...); err != nil {
+		return err
+	}
+
+	for _, list := range lists {
+		query, args := todo.Lists.INSERT(
+			todo.Lists.Title,
+			todo.Lists.Description,
+			todo.Lists.IsPinned,
+			todo.Lists.Category,
+			todo.Lists.UserID,
+		).
+			VALUES(
+				list.title,
+				list.description,
+				list.isPinned,
+				list.category,
+				userID,
+			).
+			RETURNING(todo.Lists.ListID).
+			Sql()
+
+		rows, _ := tx.Query(ctx, query, args...)
+		listID, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+		if err != nil {
+			return err
+		}
+
+		if len(list.items) == 0 {
+			continue
+		}
+
+		stmt := todo.Items.INSERT(
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.ListID,
+			todo.Items.Position,
+			todo.Items.CompletedAt,
+		)
+
+		for n, item := range list.items {
+			completedAt := pg.TimestampzExp(pg.NULL)
+
+			if item.isComplete {
+				completedAt = pg.NOW()
+			}
+
+			stmt = stmt.VALUES(item.content, item.isComplete, listID, n+1, completedAt)
+		}
+
+		query, args = stmt.Sql()
+
+		if _, err := tx.Exec(ctx, query, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func main() {
+	userID := flag.String("user", "", "id (JWT subject) of the user to seed")
+	flag.Parse()
+
+	if *userID == "" {
+		slog.Error("-user is required")
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, os.Getenv("DATABASE_URL"))
+
+	if err != nil {
+		slog.Error("Unable to connect to database", "err", err)
+		os.Exit(1)
+	}
+
+	defer conn.Close(ctx)
+
+	tx, err := conn.Begin(ctx)
+
+	if err != nil {
+		slog.Error("Error starting transaction", "err", err)
+		os.Exit(1)
+	}
+
+	defer tx.Rollback(ctx)
+
+	if err := seed(ctx, tx, *userID); err != nil {
+		slog.Error("Error seeding database", "err", err)
+		os.Exit(1)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		slog.Error("Error committing transaction", "err", err)
+		os.Exit(1)
+	}
+
+	slog.Info("Seeded database", "user_id", *userID, "lists", len(lists))
+}
@@ -2,721 +2,5135 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
+	"unicode/utf8"
 
 	_ "github.com/joho/godotenv/autoload"
 
+	"github.com/bradydean/go-todo-api/internal/pkg/config"
+	"github.com/bradydean/go-todo-api/internal/pkg/dedupe"
+	"github.com/bradydean/go-todo-api/internal/pkg/features"
+	"github.com/bradydean/go-todo-api/internal/pkg/idempotency"
+	"github.com/bradydean/go-todo-api/internal/pkg/jsonapi"
 	"github.com/bradydean/go-todo-api/internal/pkg/jwtmiddleware"
+	"github.com/bradydean/go-todo-api/internal/pkg/lrucache"
+	"github.com/bradydean/go-todo-api/internal/pkg/markdown"
+	"github.com/bradydean/go-todo-api/internal/pkg/mergepatch"
+	"github.com/bradydean/go-todo-api/internal/pkg/metrics"
+	"github.com/bradydean/go-todo-api/internal/pkg/problem"
+	"github.com/bradydean/go-todo-api/internal/pkg/querybudget"
+	"github.com/bradydean/go-todo-api/internal/pkg/ratelimit"
+	"github.com/bradydean/go-todo-api/internal/pkg/requestlog"
+	"github.com/bradydean/go-todo-api/internal/pkg/strictjson"
+	"github.com/bradydean/go-todo-api/internal/pkg/tracing"
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 
 	todo "github.com/bradydean/go-todo-api/internal/pkg/todo_api/todo/table"
 	pg "github.com/go-jet/jet/v2/postgres"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type ListRequest struct {
 	Title       string `json:"title"`
 	Description string `json:"description"`
+	IsPinned    bool   `json:"is_pinned"`
+	Category    string `json:"category"`
 }
 
+// ListPartialRequest is a JSON Merge Patch of a list. A null description
+// clears it, the other fields can't be null.
 type ListPartialRequest struct {
-	Title       *string `json:"title"`
-	Description *string `json:"description"`
+	Title       mergepatch.Field[string] `json:"title"`
+	Description mergepatch.Field[string] `json:"description"`
+	IsPinned    mergepatch.Field[bool]   `json:"is_pinned"`
+	Category    mergepatch.Field[string] `json:"category"`
 }
 
 type ListResponse struct {
-	ListID      int64  `json:"list_id"`
-	Title       string `json:"title"`
-	Description string `json:"description"`
+	ListID      int64     `json:"list_id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	IsPinned    bool      `json:"is_pinned"`
+	Category    string    `json:"category"`
+	Version     int64     `json:"version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type Progress struct {
+	Total    int64 `json:"total"`
+	Complete int64 `json:"complete"`
+}
+
+type ListProgressResponse struct {
+	ListResponse
+	Progress Progress `json:"progress"`
+}
+
+type ListRenderedResponse struct {
+	ListResponse
+	DescriptionHTML string `json:"description_html"`
 }
 
 type ItemRequest struct {
-	Content    string `json:"content"`
-	IsComplete bool   `json:"is_complete"`
+	Content         string     `json:"content"`
+	IsComplete      bool       `json:"is_complete"`
+	BlockedByItemID *int64     `json:"blocked_by_item_id"`
+	DueDate         *time.Time `json:"due_date"`
+	Priority        string     `json:"priority"`
 }
 
+// ItemPartialRequest is a JSON Merge Patch of an item. A null
+// blocked_by_item_id or due_date clears it, the other fields can't be null.
 type ItemPartialRequest struct {
-	Content    *string `json:"content"`
-	IsComplete *bool   `json:"is_complete"`
+	Content         mergepatch.Field[string]    `json:"content"`
+	IsComplete      mergepatch.Field[bool]      `json:"is_complete"`
+	BlockedByItemID mergepatch.Field[int64]     `json:"blocked_by_item_id"`
+	DueDate         mergepatch.Field[time.Time] `json:"due_date"`
+	Priority        mergepatch.Field[string]    `json:"priority"`
 }
 
 type ItemResponse struct {
-	ItemID     int64  `json:"item_id"`
-	Content    string `json:"content"`
-	IsComplete bool   `json:"is_complete"`
+	ItemID          int64      `json:"item_id"`
+	Content         string     `json:"content"`
+	IsComplete      bool       `json:"is_complete"`
+	CompletedAt     *time.Time `json:"completed_at"`
+	BlockedByItemID *int64     `json:"blocked_by_item_id"`
+	Position        int64      `json:"position"`
+	DueDate         *time.Time `json:"due_date"`
+	Priority        string     `json:"priority"`
+	Version         int64      `json:"version"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type ListItemResponse struct {
+	ListID int64 `json:"list_id"`
+	ItemResponse
+}
+
+type ItemBatchRequest struct {
+	Items []ItemRequest `json:"items"`
+}
+
+type MoveRequest struct {
+	TargetListID int64 `json:"target_list_id"`
+}
+
+type PositionRequest struct {
+	Position int64 `json:"position"`
+}
+
+type ListWithItemsResponse struct {
+	ListResponse
+	Items []ItemResponse `json:"items"`
+}
+
+type ImportResponse struct {
+	Lists int `json:"lists"`
+	Items int `json:"items"`
+}
+
+type MergeRequest struct {
+	SourceListID int64 `json:"source_list_id"`
+	DeleteSource bool  `json:"delete_source"`
+}
+
+type ExportResponse struct {
+	Lists []ListWithItemsResponse `json:"lists"`
+}
+
+type PrintResponse struct {
+	Lists []ListWithItemsResponse `json:"lists"`
+}
+
+// ToggleCascadeResponse is the toggled item and the id of every item that
+// was completed along with it, itself included.
+type ToggleCascadeResponse struct {
+	Item            ItemResponse `json:"item"`
+	AffectedItemIDs []int64      `json:"affected_item_ids"`
+}
+
+// ItemPageResponse is a page of items fetched with ?after and ?limit.
+// NextCursor is the after for the next page, null on the last page.
+type ItemPageResponse struct {
+	Items      []ItemResponse `json:"items"`
+	NextCursor *int64         `json:"next_cursor"`
+}
+
+type SearchResponse struct {
+	Lists []ListResponse     `json:"lists"`
+	Items []ListItemResponse `json:"items"`
+}
+
+// SearchPageResponse is a page of search results fetched with ?limit,
+// ?lists_after and ?items_after. Lists and items are paged separately, each
+// cursor is null once there are no more of its kind.
+type SearchPageResponse struct {
+	SearchResponse
+	NextListsCursor *int64 `json:"next_lists_cursor"`
+	NextItemsCursor *int64 `json:"next_items_cursor"`
+}
+
+type StatsResponse struct {
+	Lists              int64   `json:"lists"`
+	Items              int64   `json:"items"`
+	CompletedItems     int64   `json:"completed_items"`
+	CompletionPercent  float64 `json:"completion_percent"`
+	CompletedLast7Days int64   `json:"completed_last_7_days"`
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+// UpdatedResponse answers writes that change many items at once. Only the
+// count is returned, the items themselves would be an unbounded body, so
+// clients GET the list again for their new versions.
+type UpdatedResponse struct {
+	Updated int64 `json:"updated"`
+}
+
+type DeletedResponse struct {
+	Deleted int64 `json:"deleted"`
+}
+
+type DigestResponse struct {
+	Digest string `json:"digest"`
+}
+
+type UsageResponse struct {
+	StorageBytes    int64 `json:"storage_bytes"`
+	MaxStorageBytes int64 `json:"max_storage_bytes"`
+}
+
+type WhoAmIResponse struct {
+	Subject  string         `json:"subject"`
+	Audience []string       `json:"audience"`
+	Issuer   string         `json:"issuer"`
+	Expiry   time.Time      `json:"expiry"`
+	Claims   map[string]any `json:"claims"`
+}
+
+func (l ListResponse) Resource(c echo.Context) jsonapi.Resource {
+	return jsonapi.Resource{
+		Type:       "lists",
+		ID:         strconv.FormatInt(l.ListID, 10),
+		Attributes: l,
+	}
+}
+
+func (l ListRenderedResponse) Resource(c echo.Context) jsonapi.Resource {
+	resource := l.ListResponse.Resource(c)
+	resource.Attributes = l
+	return resource
+}
+
+func (l ListProgressResponse) Resource(c echo.Context) jsonapi.Resource {
+	resource := l.ListResponse.Resource(c)
+	resource.Attributes = l
+	return resource
+}
+
+func (l ListWithItemsResponse) Resource(c echo.Context) jsonapi.Resource {
+	resource := l.ListResponse.Resource(c)
+	resource.Attributes = l
+	return resource
+}
+
+func (i ItemResponse) Resource(c echo.Context) jsonapi.Resource {
+	return jsonapi.Resource{
+		Type:       "items",
+		ID:         strconv.FormatInt(i.ItemID, 10),
+		Attributes: i,
+		Relationships: map[string]jsonapi.Relationship{
+			"list": {Data: jsonapi.ResourceIdentifier{Type: "lists", ID: c.Param("list_id")}},
+		},
+	}
+}
+
+func (i ListItemResponse) Resource(c echo.Context) jsonapi.Resource {
+	resource := i.ItemResponse.Resource(c)
+	resource.Attributes = i
+	resource.Relationships = map[string]jsonapi.Relationship{
+		"list": {Data: jsonapi.ResourceIdentifier{Type: "lists", ID: strconv.FormatInt(i.ListID, 10)}},
+	}
+	return resource
 }
 
 type ListsRecord struct {
-	ListID      int64  `db:"lists.list_id"`
-	Title       string `db:"lists.title"`
-	Description string `db:"lists.description"`
+	ListID      int64     `db:"lists.list_id"`
+	Title       string    `db:"lists.title"`
+	Description string    `db:"lists.description"`
+	IsPinned    bool      `db:"lists.is_pinned"`
+	Category    string    `db:"lists.category"`
+	Version     int64     `db:"lists.version"`
+	CreatedAt   time.Time `db:"lists.created_at"`
+	UpdatedAt   time.Time `db:"lists.updated_at"`
 }
 
 type ItemsRecord struct {
-	ItemID     int64  `db:"items.item_id"`
-	Content    string `db:"items.content"`
-	IsComplete bool   `db:"items.is_complete"`
+	ItemID          int64      `db:"items.item_id"`
+	Content         string     `db:"items.content"`
+	IsComplete      bool       `db:"items.is_complete"`
+	CompletedAt     *time.Time `db:"items.completed_at"`
+	BlockedByItemID *int64     `db:"items.blocked_by_item_id"`
+	Position        int64      `db:"items.position"`
+	DueDate         *time.Time `db:"items.due_date"`
+	Priority        string     `db:"items.priority"`
+	Version         int64      `db:"items.version"`
+	CreatedAt       time.Time  `db:"items.created_at"`
+	UpdatedAt       time.Time  `db:"items.updated_at"`
+}
+
+// ItemDigestRecord holds the item columns a list digest is computed over.
+type ItemDigestRecord struct {
+	ItemID     int64  `db:"items.item_id" json:"item_id"`
+	Content    string `db:"items.content" json:"content"`
+	IsComplete bool   `db:"items.is_complete" json:"is_complete"`
+	Position   int64  `db:"items.position" json:"position"`
+}
+
+type ItemPositionRecord struct {
+	ItemID   int64 `db:"items.item_id"`
+	Position int64 `db:"items.position"`
+}
+
+type listCacheKey struct {
+	userID string
+	listID int64
+}
+
+// cachedList is a list's encoded body along with when it was last updated,
+// for Last-Modified.
+type cachedList struct {
+	body      []byte
+	updatedAt time.Time
+}
+
+type toggleKey struct {
+	userID  string
+	listID  int64
+	itemID  int64
+	cascade bool
+}
+
+// toggleResult is the toggled item and, with ?cascade=true, the items that
+// were completed because of it.
+type toggleResult struct {
+	record    ItemsRecord
+	completed []int64
+}
+
+type ListProgressRecord struct {
+	ListsRecord
+	Total    int64 `db:"progress.total"`
+	Complete int64 `db:"progress.complete"`
+}
+
+type StatsRecord struct {
+	Lists              int64 `db:"stats.lists"`
+	Items              int64 `db:"stats.items"`
+	CompletedItems     int64 `db:"stats.completed_items"`
+	CompletedLast7Days int64 `db:"stats.completed_last_7_days"`
+}
+
+// ListJoinItemsRecord is a list left joined to one of its items. The item
+// columns are NULL for a list without any matching items.
+type ListJoinItemsRecord struct {
+	ListsRecord
+	ItemID          *int64     `db:"items.item_id"`
+	Content         *string    `db:"items.content"`
+	IsComplete      *bool      `db:"items.is_complete"`
+	CompletedAt     *time.Time `db:"items.completed_at"`
+	BlockedByItemID *int64     `db:"items.blocked_by_item_id"`
+	Position        *int64     `db:"items.position"`
+	DueDate         *time.Time `db:"items.due_date"`
+	Priority        *string    `db:"items.priority"`
+	ItemVersion     *int64     `db:"items.version"`
+	ItemCreatedAt   *time.Time `db:"items.created_at"`
+	ItemUpdatedAt   *time.Time `db:"items.updated_at"`
+}
+
+// Item returns the joined item, ok is false when there isn't one.
+func (r ListJoinItemsRecord) Item() (item ItemResponse, ok bool) {
+	if r.ItemID == nil {
+		return ItemResponse{}, false
+	}
+
+	return ItemResponse{
+		ItemID:          *r.ItemID,
+		Content:         *r.Content,
+		IsComplete:      *r.IsComplete,
+		CompletedAt:     r.CompletedAt,
+		BlockedByItemID: r.BlockedByItemID,
+		Position:        *r.Position,
+		DueDate:         r.DueDate,
+		Priority:        *r.Priority,
+		Version:         *r.ItemVersion,
+		CreatedAt:       *r.ItemCreatedAt,
+		UpdatedAt:       *r.ItemUpdatedAt,
+	}, true
+}
+
+type ListItemsRecord struct {
+	ListID int64 `db:"items.list_id"`
+	ItemsRecord
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 200
+	maxBatchItems    = 100
+
+	// The columns are unbounded text, these keep titles and items to
+	// something a client can display.
+	maxTitleLength   = 200
+	maxContentLength = 2000
+
+	// A double tap on a checkbox sends two toggles close together, they are
+	// collapsed into one when they arrive within this long of each other.
+	toggleDedupeWindow = 500 * time.Millisecond
+)
+
+var listCategories = []string{"personal", "work", "shopping", "other"}
+
+var itemPriorities = []string{"low", "medium", "high"}
+
+// itemPriorityRank orders priorities by urgency rather than alphabetically.
+var itemPriorityRank = pg.CASE(todo.Items.Priority).
+	WHEN(pg.String("low")).THEN(pg.Int(1)).
+	WHEN(pg.String("medium")).THEN(pg.Int(2)).
+	WHEN(pg.String("high")).THEN(pg.Int(3))
+
+var listSortColumns = map[string]pg.Expression{
+	"list_id":   todo.Lists.ListID,
+	"title":     todo.Lists.Title,
+	"is_pinned": todo.Lists.IsPinned,
+	"category":  todo.Lists.Category,
+}
+
+var itemSortColumns = map[string]pg.Expression{
+	"item_id":     todo.Items.ItemID,
+	"content":     todo.Items.Content,
+	"is_complete": todo.Items.IsComplete,
+	"position":    todo.Items.Position,
+	"due_date":    todo.Items.DueDate,
+	"priority":    itemPriorityRank,
 }
 
 var (
-	ErrNotFound = echo.NewHTTPError(
+	ErrNotFound = problem.New(
 		http.StatusNotFound,
-		map[string]string{"message": "Not found"},
+		"The requested resource was not found",
 	)
 
-	ErrInternalServerError = echo.NewHTTPError(http.StatusInternalServerError)
-)
+	ErrInternalServerError = problem.New(http.StatusInternalServerError, "")
 
-func main() {
-	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
-	defer stop()
+	ErrQueryTooLong = problem.New(
+		http.StatusRequestURITooLong,
+		"Query string too long",
+	)
 
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	ErrQueryTimeout = problem.New(
+		http.StatusServiceUnavailable,
+		"Query timed out",
+	)
 
-	e := echo.New()
-	e.HideBanner = true
-	e.HidePort = true
+	ErrDatabaseUnavailable = problem.New(
+		http.StatusServiceUnavailable,
+		"Database is unreachable",
+	)
 
-	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
-		LogLevel: 4,
-	}))
-	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
-		LogStatus:    true,
-		LogURI:       true,
-		LogLatency:   true,
-		LogProtocol:  true,
-		LogMethod:    true,
-		LogUserAgent: true,
-		LogRemoteIP:  true,
-		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
-			msg := fmt.Sprintf(
-				"uri=%s status=%d latency=%s protocol=%s method=%s user_agent=%s remote_ip=%s",
-				v.URI, v.Status, v.Latency, v.Protocol, v.Method, v.UserAgent, v.RemoteIP,
+	ErrContentRequired = problem.New(
+		http.StatusUnprocessableEntity,
+		"content is required",
+	)
+
+	ErrContentTooLong = problem.New(
+		http.StatusUnprocessableEntity,
+		fmt.Sprintf("content must be at most %d characters", maxContentLength),
+	)
+
+	ErrTitleRequired = problem.New(
+		http.StatusUnprocessableEntity,
+		"title is required",
+	)
+
+	ErrVersionMismatch = problem.New(
+		http.StatusPreconditionFailed,
+		"The resource has changed since the version in If-Match",
+	)
+
+	ErrInvalidIfMatch = problem.New(
+		http.StatusBadRequest,
+		"If-Match must be a version number",
+	)
+
+	ErrDuplicateTitle = problem.New(
+		http.StatusConflict,
+		"You already have a list with this title",
+	)
+
+	ErrTitleTooLong = problem.New(
+		http.StatusUnprocessableEntity,
+		fmt.Sprintf("title must be at most %d characters", maxTitleLength),
+	)
+
+	ErrInvalidPriority = problem.New(
+		http.StatusUnprocessableEntity,
+		"priority must be one of low, medium, high",
+	)
+
+	ErrInvalidBlocker = problem.New(
+		http.StatusUnprocessableEntity,
+		"blocked_by_item_id must reference an item in the same list",
+	)
+
+	ErrSelfBlocked = problem.New(
+		http.StatusUnprocessableEntity,
+		"an item cannot block itself",
+	)
+
+	ErrBlockedCycle = problem.New(
+		http.StatusUnprocessableEntity,
+		"blocked_by_item_id would create a dependency cycle",
+	)
+
+	ErrDependencyTooDeep = problem.New(
+		http.StatusUnprocessableEntity,
+		"blocked_by_item_id would make the dependency chain too long",
+	)
+
+	ErrStorageQuotaExceeded = problem.New(
+		http.StatusForbidden,
+		"Storage quota exceeded",
+	)
+
+	ErrInvalidImportMode = problem.New(
+		http.StatusBadRequest,
+		"mode must be merge or replace",
+	)
+
+	ErrMergeSameList = problem.New(
+		http.StatusUnprocessableEntity,
+		"source_list_id must be a different list",
+	)
+
+	ErrInvalidCategory = problem.New(
+		http.StatusBadRequest,
+		"category must be one of personal, work, shopping, other",
+	)
+
+	ErrEmptyListPatch = problem.New(
+		http.StatusUnprocessableEntity,
+		"at least one of title, description, is_pinned or category is required",
+	)
+
+	ErrEmptyItemPatch = problem.New(
+		http.StatusUnprocessableEntity,
+		"at least one of content, is_complete, blocked_by_item_id, due_date or priority is required",
+	)
+
+	ErrInvalidPosition = problem.New(
+		http.StatusUnprocessableEntity,
+		"position must be between 1 and the number of items in the list",
+	)
+
+	ErrInvalidBatchSize = problem.New(
+		http.StatusUnprocessableEntity,
+		fmt.Sprintf("items must contain between 1 and %d items", maxBatchItems),
+	)
+
+	ErrInvalidLimit = problem.New(
+		http.StatusBadRequest,
+		fmt.Sprintf("limit must be between 1 and %d", maxListLimit),
+	)
+
+	ErrSortWithCursor = problem.New(
+		http.StatusBadRequest,
+		"sort can't be combined with after or limit, pages are ordered by item_id",
+	)
+
+	ErrInvalidOffset = problem.New(
+		http.StatusBadRequest,
+		"offset must not be negative",
+	)
+
+	ErrInvalidIsComplete = problem.New(
+		http.StatusBadRequest,
+		"is_complete must be true or false",
+	)
+
+	ErrInvalidDueBefore = problem.New(
+		http.StatusBadRequest,
+		"due_before must be an RFC 3339 timestamp",
+	)
+
+	ErrInvalidHasIncomplete = problem.New(
+		http.StatusBadRequest,
+		"has_incomplete must be true or false",
+	)
+
+	ErrInvalidInclude = problem.New(
+		http.StatusBadRequest,
+		"include must be progress",
+	)
+
+	ErrInvalidExpand = problem.New(
+		http.StatusBadRequest,
+		"expand must be items",
+	)
+
+	ErrInvalidRender = problem.New(
+		http.StatusBadRequest,
+		"render must be html",
+	)
+
+	ErrMissingSearchQuery = problem.New(
+		http.StatusBadRequest,
+		"q is required",
+	)
+
+	ErrInvalidFormat = problem.New(
+		http.StatusBadRequest,
+		"format must be csv",
+	)
+)
+
+// PUT_UPSERT inserts lists with a client chosen list_id, bypassing the
+// sequence. The table is locked for the rest of the transaction so no
+// concurrent insert can draw that id from the sequence, and the sequence is
+// then moved past the id if it is behind it.
+const (
+	lockListsForUpsert = `LOCK TABLE "todo"."lists" IN SHARE ROW EXCLUSIVE MODE`
+	bumpListIDSequence = `SELECT setval('"todo"."lists_list_id_seq"', $1) FROM "todo"."lists_list_id_seq" WHERE last_value < $1`
+)
+
+// dependentDepth is the length of the longest chain of items transitively
+// blocked by the item $1, 0 when nothing is blocked by it.
+const dependentDepth = `WITH RECURSIVE "dependents" AS (
+	SELECT "item_id", 1 AS "depth" FROM "todo"."items" WHERE "blocked_by_item_id" = $1
+	UNION ALL
+	SELECT "items"."item_id", "dependents"."depth" + 1
+	FROM "todo"."items" INNER JOIN "dependents" ON "items"."blocked_by_item_id" = "dependents"."item_id"
+)
+SELECT COALESCE(MAX("depth"), 0) FROM "dependents"`
+
+// completeDependents completes every incomplete item in list $2 that is
+// transitively blocked by the item $1, returning their ids. UNION rather than
+// UNION ALL stops at items already seen, so a cycle can't loop forever.
+const completeDependents = `WITH RECURSIVE "dependents" AS (
+	SELECT "item_id" FROM "todo"."items" WHERE "blocked_by_item_id" = $1
+	UNION
+	SELECT "items"."item_id"
+	FROM "todo"."items" INNER JOIN "dependents" ON "items"."blocked_by_item_id" = "dependents"."item_id"
+)
+UPDATE "todo"."items"
+SET "is_complete" = TRUE, "completed_at" = NOW(), "updated_at" = NOW(), "version" = "version" + 1
+WHERE "item_id" IN (SELECT "item_id" FROM "dependents") AND "item_id" <> $1 AND "list_id" = $2 AND NOT "is_complete"
+RETURNING "item_id"`
+
+// reorderItems sets the position of each item in a VALUES list of
+// ("item_id", "position") rows, filled in with fmt.Sprintf.
+const reorderItems = `UPDATE "todo"."items"
+SET "position" = "moved"."position", "updated_at" = NOW(), "version" = "items"."version" + 1
+FROM (VALUES %s) AS "moved" ("item_id", "position")
+WHERE "items"."item_id" = "moved"."item_id"`
+
+// parseSort turns a ?sort= value like "title,-list_id" into ORDER BY clauses.
+// A leading "-" sorts descending. Only fields in columns are accepted, and id
+// is appended as a tie-breaker so pages are stable.
+func parseSort(value string, columns map[string]pg.Expression, id pg.Expression) ([]pg.OrderByClause, error) {
+	var orderBy []pg.OrderByClause
+	hasID := false
+
+	for _, field := range strings.Split(value, ",") {
+		name, descending := strings.CutPrefix(field, "-")
+		column, ok := columns[name]
+
+		if !ok {
+			return nil, problem.New(
+				http.StatusBadRequest,
+				fmt.Sprintf("cannot sort by %q", name),
 			)
-			if v.Error == nil {
-				logger.LogAttrs(context.Background(), slog.LevelInfo, msg,
-					slog.String("uri", v.URI),
-					slog.Int("status", v.Status),
-					slog.Duration("latency", v.Latency),
-					slog.String("protocol", v.Protocol),
-					slog.String("method", v.Method),
-					slog.String("user_agent", v.UserAgent),
-					slog.String("remote_ip", v.RemoteIP),
-				)
-			} else {
-				logger.LogAttrs(context.Background(), slog.LevelError, msg,
-					slog.String("uri", v.URI),
-					slog.Int("status", v.Status),
-					slog.Duration("latency", v.Latency),
-					slog.String("protocol", v.Protocol),
-					slog.String("method", v.Method),
-					slog.String("err", v.Error.Error()),
-					slog.String("user_agent", v.UserAgent),
-					slog.String("remote_ip", v.RemoteIP),
-				)
-			}
-			return nil
-		},
-	}))
+		}
 
-	JWT, err := jwtmiddleware.New()
+		hasID = hasID || column == id
 
-	if err != nil {
-		e.Logger.Fatalf("Unable to create JWT middleware: %v\n", err)
+		if descending {
+			orderBy = append(orderBy, column.DESC())
+		} else {
+			orderBy = append(orderBy, column.ASC())
+		}
 	}
 
-	e.Use(JWT)
-	e.Use(jwtmiddleware.UserID)
+	if !hasID {
+		orderBy = append(orderBy, id.ASC())
+	}
 
-	db, err := pgxpool.New(context.Background(), os.Getenv("DATABASE_URL"))
+	return orderBy, nil
+}
 
-	if err != nil {
-		e.Logger.Fatalf("Unable to connect to database: %v\n", err)
+// prefersUnchanged reports whether the client sent "Prefer: unchanged", asking
+// PATCH to skip the update when it wouldn't change anything.
+func prefersUnchanged(c echo.Context) bool {
+	for _, value := range c.Request().Header.Values("Prefer") {
+		for _, preference := range strings.Split(value, ",") {
+			if strings.TrimSpace(preference) == "unchanged" {
+				return true
+			}
+		}
 	}
 
-	defer db.Close()
+	return false
+}
 
-	e.GET("/list", func(c echo.Context) error {
-		userID := c.Get("userID").(string)
+// nullField returns the error for a merge patch that sets a field which
+// can't be cleared to null.
+func nullField(name string) *echo.HTTPError {
+	return problem.New(http.StatusUnprocessableEntity, name+" can't be null")
+}
 
-		query, args := pg.SELECT(
-			todo.Lists.ListID,
-			todo.Lists.Title,
-			todo.Lists.Description,
-		).
-			FROM(todo.Lists).
-			WHERE(todo.Lists.UserID.EQ(pg.String(userID))).
-			ORDER_BY(todo.Lists.ListID).
-			Sql()
+// checkTitle returns the error for a list title that is blank or too long.
+func checkTitle(title string) *echo.HTTPError {
+	if strings.TrimSpace(title) == "" {
+		return ErrTitleRequired
+	}
 
-		rows, _ := db.Query(c.Request().Context(), query, args...)
-		records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListsRecord])
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return ErrTitleTooLong
+	}
 
-		if err != nil {
-			c.Logger().Errorf("Error fetching lists: %v\n", err)
-			return ErrInternalServerError
+	return nil
+}
+
+// copyTitle returns the title for a copy of a list called title: title with
+// " (copy)" on the end, or " (copy N)" with the lowest N from 2 on when that
+// is already taken. title is cut short so the result still fits. taken holds
+// the lowercased titles in use.
+func copyTitle(title string, taken map[string]bool) string {
+	for n := 1; ; n++ {
+		suffix := " (copy)"
+
+		if n > 1 {
+			suffix = fmt.Sprintf(" (copy %d)", n)
 		}
 
-		var lists = make([]ListResponse, 0, len(records))
+		base := []rune(title)
 
-		for _, record := range records {
-			lists = append(lists, ListResponse(record))
+		if room := maxTitleLength - utf8.RuneCountInString(suffix); len(base) > room {
+			base = base[:room]
 		}
 
-		return c.JSON(http.StatusOK, lists)
-	})
+		if candidate := string(base) + suffix; !taken[strings.ToLower(candidate)] {
+			return candidate
+		}
+	}
+}
 
-	e.GET("/list/:list_id", func(c echo.Context) error {
-		userID := c.Get("userID").(string)
-		var listID int64
+// checkContent returns the error for item content that is empty or too long.
+// Content is trimmed before it is checked.
+func checkContent(content string) *echo.HTTPError {
+	if content == "" {
+		return ErrContentRequired
+	}
 
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+	if utf8.RuneCountInString(content) > maxContentLength {
+		return ErrContentTooLong
+	}
+
+	return nil
+}
+
+// containsPattern returns a LIKE pattern matching any string that contains
+// term, with LIKE's wildcards in term escaped.
+func containsPattern(term string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(term)
+	return "%" + escaped + "%"
+}
+
+// ifMatchVersion returns the version the client expects to update, sent as
+// `If-Match: "3"`. ok is false when there is no If-Match header.
+func ifMatchVersion(c echo.Context) (version int64, ok bool, err error) {
+	value := c.Request().Header.Get("If-Match")
+
+	if value == "" {
+		return 0, false, nil
+	}
+
+	version, err = strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+
+	if err != nil {
+		return 0, false, ErrInvalidIfMatch
+	}
+
+	return version, true, nil
+}
+
+// notModified sets Last-Modified to updatedAt and reports whether the client
+// already has this version, going by If-Modified-Since. HTTP dates only have
+// whole seconds, so updatedAt is compared at that precision and a change in
+// the same second as the client's copy goes unnoticed, If-Match versions
+// don't have that gap. An If-Modified-Since that can't be parsed is ignored.
+func notModified(c echo.Context, updatedAt time.Time) bool {
+	updatedAt = updatedAt.UTC().Truncate(time.Second)
+	c.Response().Header().Set(echo.HeaderLastModified, updatedAt.Format(http.TimeFormat))
+
+	since, err := http.ParseTime(c.Request().Header.Get(echo.HeaderIfModifiedSince))
+	return err == nil && !updatedAt.After(since)
+}
+
+// querier is implemented by both *pgxpool.Pool and pgx.Tx.
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// queryTracers passes pgx's query tracing hooks on to each tracer in turn.
+type queryTracers []pgx.QueryTracer
+
+func (t queryTracers) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	for _, tracer := range t {
+		ctx = tracer.TraceQueryStart(ctx, conn, data)
+	}
+	return ctx
+}
+
+func (t queryTracers) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	for _, tracer := range t {
+		tracer.TraceQueryEnd(ctx, conn, data)
+	}
+}
+
+// storageUsage returns the number of bytes of text a user has stored across
+// list titles, list descriptions and item content. Lists in the trash, and
+// their items, don't count until they're restored.
+func storageUsage(ctx context.Context, db querier, userID string) (int64, error) {
+	listBytes := pg.SELECT(
+		pg.COALESCE(
+			pg.SUMi(pg.OCTET_LENGTH(todo.Lists.Title).ADD(pg.OCTET_LENGTH(todo.Lists.Description))),
+			pg.Int(0),
+		),
+	).
+		FROM(todo.Lists).
+		WHERE(todo.Lists.UserID.EQ(pg.String(userID)).AND(todo.Lists.DeletedAt.IS_NULL()))
+
+	itemBytes := pg.SELECT(
+		pg.COALESCE(pg.SUMi(pg.OCTET_LENGTH(todo.Items.Content)), pg.Int(0)),
+	).
+		FROM(todo.Items.INNER_JOIN(todo.Lists, todo.Lists.ListID.EQ(todo.Items.ListID))).
+		WHERE(todo.Lists.UserID.EQ(pg.String(userID)).AND(todo.Lists.DeletedAt.IS_NULL()))
+
+	query, args := pg.SELECT(pg.IntExp(listBytes).ADD(pg.IntExp(itemBytes)).AS("usage")).Sql()
+
+	rows, _ := db.Query(ctx, query, args...)
+	return pgx.CollectOneRow(rows, pgx.RowTo[int64])
+}
+
+// requestLogger returns the logger for the request, which tags every record
+// with the request id and, on the api group, the user id.
+func requestLogger(c echo.Context) *slog.Logger {
+	return requestlog.Logger(c)
+}
+
+// listExists reports whether userID has a list listID that isn't deleted.
+func listExists(ctx context.Context, db querier, userID string, listID int64) (bool, error) {
+	query, args := pg.SELECT(pg.Int64(1)).
+		FROM(todo.Lists).
+		WHERE(
+			todo.Lists.ListID.EQ(pg.Int(listID)).
+				AND(todo.Lists.UserID.EQ(pg.String(userID))).
+				AND(todo.Lists.DeletedAt.IS_NULL()),
+		).
+		Sql()
+
+	rows, _ := db.Query(ctx, query, args...)
+	_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// itemExists reports whether itemID is in listID and userID owns the list.
+func itemExists(ctx context.Context, db querier, userID string, listID, itemID int64) (bool, error) {
+	query, args := pg.SELECT(pg.Int64(1)).
+		FROM(todo.Items.INNER_JOIN(todo.Lists, todo.Lists.ListID.EQ(todo.Items.ListID))).
+		WHERE(
+			todo.Items.ItemID.EQ(pg.Int(itemID)).
+				AND(todo.Items.ListID.EQ(pg.Int(listID))).
+				AND(todo.Lists.UserID.EQ(pg.String(userID))).
+				AND(todo.Lists.DeletedAt.IS_NULL()),
+		).
+		Sql()
+
+	rows, _ := db.Query(ctx, query, args...)
+	_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// dbError maps an error from a database call to the error returned to the
+// client. Queries cancelled by statement_timeout become a 503, a second list
+// with the same title a 409, anything else is an internal server error.
+func dbError(err error) *echo.HTTPError {
+	var pgErr *pgconn.PgError
+
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "57014":
+			return ErrQueryTimeout
+		case pgErr.Code == "23505" && pgErr.ConstraintName == "lists_user_id_lower_title_index":
+			return ErrDuplicateTitle
+		}
+	}
+
+	return ErrInternalServerError
+}
+
+// nextItemPosition returns the position that puts a new item at the end of
+// listID. Callers must hold a lock on the list row so concurrent inserts don't
+// get the same position.
+func nextItemPosition(listID int64) pg.IntegerExpression {
+	return pg.IntExp(
+		pg.SELECT(pg.IntExp(pg.COALESCE(pg.MAXi(todo.Items.Position), pg.Int(0))).ADD(pg.Int(1))).
+			FROM(todo.Items).
+			WHERE(todo.Items.ListID.EQ(pg.Int(listID))),
+	)
+}
+
+// completedAt is the completed_at to set alongside is_complete in an item
+// UPDATE. It is only stamped when an item goes from incomplete to complete,
+// items that were already complete keep their timestamp.
+func completedAt(isComplete pg.BoolExpression) pg.TimestampzExpression {
+	return pg.TimestampzExp(
+		pg.CASE().
+			WHEN(isComplete).THEN(pg.COALESCE(todo.Items.CompletedAt, pg.NOW())).
+			ELSE(pg.NULL),
+	)
+}
+
+// newCompletedAt is the completed_at to insert for a new item.
+func newCompletedAt(isComplete bool) pg.TimestampzExpression {
+	if isComplete {
+		return pg.NOW()
+	}
+
+	return pg.TimestampzExp(pg.NULL)
+}
+
+// blockerChain returns the id of blockerID and of every item it is
+// transitively blocked by. The result is empty when blockerID is not an item
+// in listID.
+func blockerChain(ctx context.Context, db querier, listID, blockerID int64) ([]int64, error) {
+	chain := pg.CTE("chain")
+	chainItemID := todo.Items.ItemID.From(chain)
+	chainBlockedByItemID := todo.Items.BlockedByItemID.From(chain)
+
+	query, args := pg.WITH_RECURSIVE(
+		chain.AS(
+			pg.SELECT(todo.Items.ItemID, todo.Items.BlockedByItemID).
+				FROM(todo.Items).
+				WHERE(
+					todo.Items.ItemID.EQ(pg.Int(blockerID)).
+						AND(todo.Items.ListID.EQ(pg.Int(listID))),
+				).
+				UNION(
+					pg.SELECT(todo.Items.ItemID, todo.Items.BlockedByItemID).
+						FROM(todo.Items.INNER_JOIN(chain, todo.Items.ItemID.EQ(chainBlockedByItemID))),
+				),
+		),
+	)(
+		pg.SELECT(chainItemID).FROM(chain),
+	).Sql()
+
+	rows, _ := db.Query(ctx, query, args...)
+	return pgx.CollectRows(rows, pgx.RowTo[int64])
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+
+	cfg, err := config.Load()
+
+	if err != nil {
+		e.Logger.Fatalf("Invalid configuration: %v\n", err)
+	}
+
+	JWT, err := jwtmiddleware.New(cfg)
+
+	if err != nil {
+		e.Logger.Fatalf("Unable to create JWT middleware: %v\n", err)
+	}
+
+	dbConfig, err := pgxpool.ParseConfig(cfg.DatabaseURL)
+
+	if err != nil {
+		e.Logger.Fatalf("Unable to parse DATABASE_URL: %v\n", err)
+	}
+
+	if cfg.StatementTimeout != 0 {
+		dbConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+			_, err := conn.Exec(ctx, fmt.Sprintf("SET statement_timeout = %d", cfg.StatementTimeout.Milliseconds()))
+			return err
+		}
+	}
+
+	var tracers queryTracers
+
+	if cfg.OTLPEndpoint != "" {
+		shutdownTracing, err := tracing.Setup(ctx, cfg.OTLPEndpoint)
+
+		if err != nil {
+			e.Logger.Fatalf("Unable to set up tracing: %v\n", err)
+		}
+
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			if err := shutdownTracing(ctx); err != nil {
+				e.Logger.Errorf("Error flushing traces: %v\n", err)
+			}
+		}()
+
+		tracers = append(tracers, tracing.QueryTracer{})
+	}
+
+	if cfg.Debug {
+		tracers = append(tracers, querybudget.Tracer{})
+	}
+
+	if len(tracers) > 0 {
+		dbConfig.ConnConfig.Tracer = tracers
+	}
+
+	db, err := pgxpool.NewWithConfig(context.Background(), dbConfig)
+
+	if err != nil {
+		e.Logger.Fatalf("Unable to connect to database: %v\n", err)
+	}
+
+	defer db.Close()
+
+	metrics.RegisterPool(db)
+
+	go func(ctx context.Context) {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := idempotency.Purge(ctx, db); err != nil {
+					logger.Error("Error purging idempotency keys", "err", err)
+				}
+			}
+		}
+	}(ctx)
+
+	flags, err := features.Load(map[string]bool{
+		"export": true,
+		"import": true,
+		"merge":  true,
+		"usage":  true,
+	})
+
+	if err != nil {
+		e.Logger.Fatalf("Unable to load feature flags: %v\n", err)
+	}
+
+	for _, table := range []pg.ReadableTable{todo.Lists, todo.Items} {
+		query, args := pg.SELECT(pg.Int64(1)).FROM(table).LIMIT(0).Sql()
+
+		if _, err := db.Exec(ctx, query, args...); err != nil {
+			e.Logger.Fatalf("Unable to verify database schema, check that DATABASE_URL points at a migrated database: %v\n", err)
+		}
+	}
+
+	configureServer(e, cfg, db, flags, logger, JWT, jwtmiddleware.UserID)
+
+	go func() {
+		if err := e.Start(":8000"); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		e.Logger.Fatal(err)
+	}
+}
+
+// configureServer adds the middleware and routes to e. auth guards the todo
+// routes, it must reject unauthenticated requests and set "userID".
+func configureServer(e *echo.Echo, cfg config.Config, db *pgxpool.Pool, flags features.Flags, logger *slog.Logger, auth ...echo.MiddlewareFunc) {
+	e.Debug = cfg.Debug
+	e.JSONSerializer = jsonapi.Serializer{JSONSerializer: strictjson.Serializer{}}
+	e.HTTPErrorHandler = problem.Handler
+
+	// Unknown paths get the same 404 body as a missing resource. The
+	// catch-all route also catches known paths requested with the wrong
+	// method, so those are looked up under the other methods to still answer
+	// with a 405.
+	notFound := func(c echo.Context) error {
+		var allowed []string
+
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			probe := e.NewContext(c.Request(), nil)
+			e.Router().Find(method, c.Request().URL.Path, probe)
+
+			// An empty path means nothing matched, the group registers its
+			// catch-all under "/" and "/*".
+			if path := probe.Path(); path != "" && path != "/" && path != "/*" {
+				allowed = append(allowed, method)
+			}
+		}
+
+		if len(allowed) > 0 {
+			c.Response().Header().Set(echo.HeaderAllow, strings.Join(allowed, ", "))
+			return echo.ErrMethodNotAllowed
+		}
+
+		return ErrNotFound
+	}
+
+	e.Use(middleware.RecoverWithConfig(middleware.RecoverConfig{
+		LogLevel: 4,
+	}))
+	e.Use(metrics.Middleware)
+	e.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		Generator: uuid.NewString,
+		RequestIDHandler: func(c echo.Context, id string) {
+			requestlog.Set(c, logger.With(slog.String("request_id", id)))
+		},
+	}))
+	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogStatus:    true,
+		LogURI:       true,
+		LogLatency:   true,
+		LogProtocol:  true,
+		LogMethod:    true,
+		LogUserAgent: true,
+		LogRemoteIP:  true,
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			msg := fmt.Sprintf(
+				"uri=%s status=%d latency=%s protocol=%s method=%s user_agent=%s remote_ip=%s",
+				v.URI, v.Status, v.Latency, v.Protocol, v.Method, v.UserAgent, v.RemoteIP,
+			)
+			if v.Error == nil {
+				requestLogger(c).LogAttrs(context.Background(), slog.LevelInfo, msg,
+					slog.String("uri", v.URI),
+					slog.Int("status", v.Status),
+					slog.Duration("latency", v.Latency),
+					slog.String("protocol", v.Protocol),
+					slog.String("method", v.Method),
+					slog.String("user_agent", v.UserAgent),
+					slog.String("remote_ip", v.RemoteIP),
+				)
+			} else {
+				requestLogger(c).LogAttrs(context.Background(), slog.LevelError, msg,
+					slog.String("uri", v.URI),
+					slog.Int("status", v.Status),
+					slog.Duration("latency", v.Latency),
+					slog.String("protocol", v.Protocol),
+					slog.String("method", v.Method),
+					slog.String("err", v.Error.Error()),
+					slog.String("user_agent", v.UserAgent),
+					slog.String("remote_ip", v.RemoteIP),
+				)
+			}
+			return nil
+		},
+	}))
+
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if len(c.Request().URL.RawQuery) > cfg.MaxQueryLength {
+				return ErrQueryTooLong
+			}
+			return next(c)
+		}
+	})
+
+	// echo lets the last param of a route swallow any extra segments, e.g.
+	// /list/5/item/3/extra matches with an item_id of "3/extra". The
+	// catch-all's "*" is left alone so notFound can still answer with a 405.
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			for i, name := range c.ParamNames() {
+				if name != "*" && strings.Contains(c.ParamValues()[i], "/") {
+					return ErrNotFound
+				}
+			}
+			return next(c)
+		}
+	})
+
+	if cfg.OTLPEndpoint != "" {
+		e.Use(tracing.Middleware)
+	}
+
+	if e.Debug {
+		e.Use(querybudget.Middleware(cfg.QueryBudget))
+	}
+
+	// Todo routes are registered on api and require a token. Infrastructure
+	// routes such as health checks are registered on e and are public.
+	api := e.Group("", auth...)
+
+	// Logs from here on, including the access log, carry the user_id.
+	api.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			requestlog.Set(c, requestLogger(c).With(slog.String("user_id", c.Get("userID").(string))))
+			return next(c)
+		}
+	})
+
+	if cfg.RateLimit > 0 {
+		api.Use(ratelimit.Middleware(cfg.RateLimit, cfg.RateLimitBurst))
+	}
+
+	// Retried POSTs carrying an Idempotency-Key get the first response back
+	// instead of creating the resource again.
+	api.Use(idempotency.Middleware(db))
+
+	// Every api.Use registers echo's default catch-all for the group again,
+	// under "" and "/*", so these have to come after the last one to replace
+	// it.
+	e.RouteNotFound("", notFound)
+	e.RouteNotFound("/*", notFound)
+
+	listCache := lrucache.New[listCacheKey, cachedList](cfg.ListCacheSize)
+
+	// Toggles are only collapsed within one instance, so with several behind
+	// a load balancer a double tap that lands on two of them still flips the
+	// item twice.
+	toggles := dedupe.New[toggleKey, toggleResult](toggleDedupeWindow)
+
+	// DELETE_RETURNS_200 is for clients that can't handle 204 No Content.
+	deleted := func(c echo.Context) error {
+		if cfg.DeleteReturns200 {
+			return c.JSON(http.StatusOK, struct{}{})
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	e.GET("/healthz", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+	})
+
+	e.GET("/metrics", metrics.Handler())
+
+	e.GET("/readyz", func(c echo.Context) error {
+		if err := db.Ping(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error pinging database", "err", err)
+			return ErrDatabaseUnavailable
+		}
+
+		return c.JSON(http.StatusOK, HealthResponse{Status: "ok"})
+	})
+
+	api.GET("/list", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		limit, offset := int64(defaultListLimit), int64(0)
+
+		if err := echo.QueryParamsBinder(c).Int64("limit", &limit).Int64("offset", &offset).BindError(); err != nil {
+			return err
+		}
+
+		if limit < 1 || limit > maxListLimit {
+			return ErrInvalidLimit
+		}
+
+		if offset < 0 {
+			return ErrInvalidOffset
+		}
+
+		orderBy := []pg.OrderByClause{todo.Lists.IsPinned.DESC(), todo.Lists.ListID.ASC()}
+
+		if sort := c.QueryParam("sort"); sort != "" {
+			var err error
+			orderBy, err = parseSort(sort, listSortColumns, todo.Lists.ListID)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		category := c.QueryParam("category")
+
+		if category != "" && !slices.Contains(listCategories, category) {
+			return ErrInvalidCategory
+		}
+
+		includeProgress := false
+
+		if include := c.QueryParam("include"); include != "" {
+			for _, field := range strings.Split(include, ",") {
+				if field != "progress" {
+					return ErrInvalidInclude
+				}
+				includeProgress = true
+			}
+		}
+
+		condition := todo.Lists.UserID.EQ(pg.String(userID)).
+			AND(todo.Lists.DeletedAt.IS_NULL())
+
+		if category != "" {
+			condition = condition.AND(todo.Lists.Category.EQ(pg.String(category)))
+		}
+
+		if value := c.QueryParam("has_incomplete"); value != "" {
+			if value != "true" && value != "false" {
+				return ErrInvalidHasIncomplete
+			}
+
+			hasIncomplete := pg.EXISTS(
+				pg.SELECT(pg.Int64(1)).
+					FROM(todo.Items).
+					WHERE(
+						todo.Items.ListID.EQ(todo.Lists.ListID).
+							AND(todo.Items.IsComplete.IS_FALSE()),
+					),
+			)
+
+			if value == "true" {
+				condition = condition.AND(hasIncomplete)
+			} else {
+				condition = condition.AND(pg.NOT(hasIncomplete))
+			}
+		}
+
+		{
+			query, args := pg.SELECT(pg.COUNT(pg.STAR)).
+				FROM(todo.Lists).
+				WHERE(condition).
+				Sql()
+
+			rows, _ := db.Query(c.Request().Context(), query, args...)
+			total, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				requestLogger(c).Error("Error counting lists", "err", err)
+				return dbError(err)
+			}
+
+			c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+		}
+
+		if includeProgress {
+			query, args := pg.SELECT(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+				pg.COUNT(todo.Items.ItemID).AS("progress.total"),
+				pg.COUNT(pg.CASE().WHEN(todo.Items.IsComplete.IS_TRUE()).THEN(pg.Int(1))).AS("progress.complete"),
+			).
+				FROM(todo.Lists.LEFT_JOIN(todo.Items, todo.Items.ListID.EQ(todo.Lists.ListID))).
+				WHERE(condition).
+				GROUP_BY(todo.Lists.ListID).
+				ORDER_BY(orderBy...).
+				LIMIT(limit).
+				OFFSET(offset).
+				Sql()
+
+			rows, _ := db.Query(c.Request().Context(), query, args...)
+			records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListProgressRecord])
+
+			if err != nil {
+				requestLogger(c).Error("Error fetching lists", "err", err)
+				return dbError(err)
+			}
+
+			var lists = make([]ListProgressResponse, 0, len(records))
+
+			for _, record := range records {
+				lists = append(lists, ListProgressResponse{
+					ListResponse: ListResponse(record.ListsRecord),
+					Progress:     Progress{Total: record.Total, Complete: record.Complete},
+				})
+			}
+
+			return c.JSON(http.StatusOK, lists)
+		}
+
+		query, args := pg.SELECT(
+			todo.Lists.ListID,
+			todo.Lists.Title,
+			todo.Lists.Description,
+			todo.Lists.IsPinned,
+			todo.Lists.Category,
+			todo.Lists.Version,
+			todo.Lists.CreatedAt,
+			todo.Lists.UpdatedAt,
+		).
+			FROM(todo.Lists).
+			WHERE(condition).
+			ORDER_BY(orderBy...).
+			LIMIT(limit).
+			OFFSET(offset).
+			Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error fetching lists", "err", err)
+			return dbError(err)
+		}
+
+		var lists = make([]ListResponse, 0, len(records))
+
+		for _, record := range records {
+			lists = append(lists, ListResponse(record))
+		}
+
+		return c.JSON(http.StatusOK, lists)
+	})
+
+	// GET /list/:list_id.md can't be registered as its own route since echo
+	// would treat it as the same route with a different param name, so
+	// GET /list/:list_id dispatches to it.
+	getListMarkdown := func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		param := strings.TrimSuffix(c.Param("list_id"), ".md")
+		listID, err := strconv.ParseInt(param, 10, 64)
+
+		if err != nil {
+			return echo.NewBindingError("list_id", []string{param}, "failed to bind field value to int64", err)
+		}
+
+		query, args := pg.SELECT(
+			todo.Lists.ListID,
+			todo.Lists.Title,
+			todo.Lists.Description,
+			todo.Lists.IsPinned,
+			todo.Lists.Category,
+			todo.Lists.Version,
+			todo.Lists.CreatedAt,
+			todo.Lists.UpdatedAt,
+		).
+			FROM(todo.Lists).
+			WHERE(
+				todo.Lists.ListID.EQ(pg.Int(listID)).
+					AND(todo.Lists.UserID.EQ(pg.String(userID))).
+					AND(todo.Lists.DeletedAt.IS_NULL()),
+			).
+			Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		list, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error fetching list", "err", err)
+			return dbError(err)
+		}
+
+		query, args = pg.SELECT(
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
+		).
+			FROM(todo.Items).
+			WHERE(todo.Items.ListID.EQ(pg.Int(listID))).
+			ORDER_BY(todo.Items.Position, todo.Items.ItemID).
+			Sql()
+
+		rows, _ = db.Query(c.Request().Context(), query, args...)
+		records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ItemsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error fetching items", "err", err)
+			return dbError(err)
+		}
+
+		items := make([]markdown.ChecklistItem, 0, len(records))
+
+		for _, record := range records {
+			items = append(items, markdown.ChecklistItem{
+				Content: record.Content,
+				Checked: record.IsComplete,
+			})
+		}
+
+		document := markdown.Checklist(list.Title, list.Description, items)
+
+		return c.Blob(http.StatusOK, "text/markdown; charset=utf-8", []byte(document))
+	}
+
+	api.GET("/list/:list_id", func(c echo.Context) error {
+		if strings.HasSuffix(c.Param("list_id"), ".md") {
+			return getListMarkdown(c)
+		}
+
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		render := c.QueryParam("render")
+
+		if render != "" && render != "html" {
+			return ErrInvalidRender
+		}
+
+		if expand := c.QueryParam("expand"); expand != "" {
+			if expand != "items" {
+				return ErrInvalidExpand
+			}
+
+			query, args := pg.SELECT(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+				todo.Items.ItemID,
+				todo.Items.Content,
+				todo.Items.IsComplete,
+				todo.Items.BlockedByItemID,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+				todo.Items.CompletedAt,
+				todo.Items.Version,
+				todo.Items.CreatedAt,
+				todo.Items.UpdatedAt,
+			).
+				FROM(todo.Lists.LEFT_JOIN(todo.Items, todo.Items.ListID.EQ(todo.Lists.ListID))).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				ORDER_BY(todo.Items.Position, todo.Items.ItemID).
+				Sql()
+
+			rows, _ := db.Query(c.Request().Context(), query, args...)
+			records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListJoinItemsRecord])
+
+			if err != nil {
+				requestLogger(c).Error("Error fetching list", "err", err)
+				return dbError(err)
+			}
+
+			if len(records) == 0 {
+				return ErrNotFound
+			}
+
+			list := ListWithItemsResponse{
+				ListResponse: ListResponse(records[0].ListsRecord),
+				Items:        make([]ItemResponse, 0, len(records)),
+			}
+
+			for _, record := range records {
+				if item, ok := record.Item(); ok {
+					list.Items = append(list.Items, item)
+				}
+			}
+
+			return c.JSON(http.StatusOK, list)
+		}
+
+		cacheKey := listCacheKey{userID: userID, listID: listID}
+
+		if render == "" && !jsonapi.Accepted(c) {
+			if cached, ok := listCache.Get(cacheKey); ok {
+				if c.Echo().Debug {
+					c.Response().Header().Set("X-Cache", "HIT")
+				}
+
+				if notModified(c, cached.updatedAt) {
+					return c.NoContent(http.StatusNotModified)
+				}
+
+				return c.JSONBlob(http.StatusOK, cached.body)
+			}
+		}
+
+		// Taken before the read so a write that lands in between keeps the
+		// row it replaced out of the cache.
+		generation := listCache.Generation()
+
+		query, args := pg.SELECT(
+			todo.Lists.ListID,
+			todo.Lists.Title,
+			todo.Lists.Description,
+			todo.Lists.IsPinned,
+			todo.Lists.Category,
+			todo.Lists.Version,
+			todo.Lists.CreatedAt,
+			todo.Lists.UpdatedAt,
+		).
+			FROM(todo.Lists).
+			WHERE(
+				todo.Lists.ListID.EQ(pg.Int(listID)).
+					AND(todo.Lists.UserID.EQ(pg.String(userID))).
+					AND(todo.Lists.DeletedAt.IS_NULL()),
+			).
+			Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error fetching list", "err", err)
+			return dbError(err)
+		}
+
+		if notModified(c, record.UpdatedAt) {
+			return c.NoContent(http.StatusNotModified)
+		}
+
+		if render == "html" {
+			html, err := markdown.RenderHTML(record.Description)
+
+			if err != nil {
+				requestLogger(c).Error("Error rendering list description", "err", err)
+				return ErrInternalServerError
+			}
+
+			return c.JSON(http.StatusOK, ListRenderedResponse{
+				ListResponse:    ListResponse(record),
+				DescriptionHTML: html,
+			})
+		}
+
+		if jsonapi.Accepted(c) {
+			return c.JSON(http.StatusOK, ListResponse(record))
+		}
+
+		body, err := json.Marshal(ListResponse(record))
+
+		if err != nil {
+			requestLogger(c).Error("Error encoding list", "err", err)
+			return ErrInternalServerError
+		}
+
+		listCache.AddIfCurrent(cacheKey, cachedList{body: body, updatedAt: record.UpdatedAt}, generation)
+
+		if c.Echo().Debug {
+			c.Response().Header().Set("X-Cache", "MISS")
+		}
+
+		return c.JSONBlob(http.StatusOK, body)
+	})
+
+	api.POST("/list", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var params ListRequest
+
+		if err := c.Bind(&params); err != nil {
+			return err
+		}
+
+		if err := checkTitle(params.Title); err != nil {
+			return err
+		}
+
+		if params.Category == "" {
+			params.Category = "other"
+		}
+
+		if !slices.Contains(listCategories, params.Category) {
+			return ErrInvalidCategory
+		}
+
+		if cfg.MaxStorageBytes > 0 {
+			usage, err := storageUsage(c.Request().Context(), db, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			if usage+int64(len(params.Title)+len(params.Description)) > cfg.MaxStorageBytes {
+				return ErrStorageQuotaExceeded
+			}
+		}
+
+		query, args := todo.Lists.INSERT(
+			todo.Lists.Title,
+			todo.Lists.Description,
+			todo.Lists.IsPinned,
+			todo.Lists.Category,
+			todo.Lists.UserID,
+		).
+			VALUES(
+				params.Title,
+				params.Description,
+				params.IsPinned,
+				params.Category,
+				userID,
+			).
+			RETURNING(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+			).
+			Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error creating list", "err", err)
+			return dbError(err)
+		}
+
+		return c.JSON(http.StatusCreated, ListResponse(record))
+	})
+
+	api.PUT("/list/:list_id", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		var params ListRequest
+
+		if err := c.Bind(&params); err != nil {
+			return err
+		}
+
+		if err := checkTitle(params.Title); err != nil {
+			return err
+		}
+
+		version, hasVersion, err := ifMatchVersion(c)
+
+		if err != nil {
+			return err
+		}
+
+		if params.Category == "" {
+			params.Category = "other"
+		}
+
+		if !slices.Contains(listCategories, params.Category) {
+			return ErrInvalidCategory
+		}
+
+		condition := todo.Lists.ListID.EQ(pg.Int(listID)).
+			AND(todo.Lists.UserID.EQ(pg.String(userID))).
+			AND(todo.Lists.DeletedAt.IS_NULL())
+
+		if hasVersion {
+			condition = condition.AND(todo.Lists.Version.EQ(pg.Int(version)))
+		}
+
+		query, args := todo.Lists.
+			UPDATE().
+			SET(
+				todo.Lists.Title.SET(pg.String(params.Title)),
+				todo.Lists.Description.SET(pg.String(params.Description)),
+				todo.Lists.IsPinned.SET(pg.Bool(params.IsPinned)),
+				todo.Lists.Category.SET(pg.String(params.Category)),
+				todo.Lists.UpdatedAt.SET(pg.NOW()),
+				todo.Lists.Version.SET(todo.Lists.Version.ADD(pg.Int(1))),
+			).
+			WHERE(condition).
+			RETURNING(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+			).
+			Sql()
+
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		var usageBefore int64
+
+		if cfg.MaxStorageBytes > 0 {
+			usageBefore, err = storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+		}
+
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+		status := http.StatusOK
+
+		// With If-Match nothing is updated when the version has moved on,
+		// which is a 412 as long as the list is still there.
+		if errors.Is(err, pgx.ErrNoRows) && hasVersion {
+			exists, err := listExists(c.Request().Context(), tx, userID, listID)
+
+			if err != nil {
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+
+			if exists {
+				return ErrVersionMismatch
+			}
+		}
+
+		// With PUT_UPSERT a missing list is created with the requested id. If
+		// the id is taken by another user's list nothing is inserted and the
+		// request stays a 404.
+		if errors.Is(err, pgx.ErrNoRows) && cfg.PutUpsert && !hasVersion {
+			if _, err := tx.Exec(c.Request().Context(), lockListsForUpsert); err != nil {
+				requestLogger(c).Error("Error locking lists", "err", err)
+				return dbError(err)
+			}
+
+			query, args := todo.Lists.INSERT(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.UserID,
+			).
+				VALUES(
+					listID,
+					params.Title,
+					params.Description,
+					params.IsPinned,
+					params.Category,
+					userID,
+				).
+				ON_CONFLICT(todo.Lists.ListID).
+				DO_NOTHING().
+				RETURNING(
+					todo.Lists.ListID,
+					todo.Lists.Title,
+					todo.Lists.Description,
+					todo.Lists.IsPinned,
+					todo.Lists.Category,
+					todo.Lists.Version,
+					todo.Lists.CreatedAt,
+					todo.Lists.UpdatedAt,
+				).
+				Sql()
+
+			rows, _ := tx.Query(c.Request().Context(), query, args...)
+			record, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+
+			if err == nil {
+				_, err = tx.Exec(c.Request().Context(), bumpListIDSequence, listID)
+				status = http.StatusCreated
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error updating list", "err", err)
+			return dbError(err)
+		}
+
+		if cfg.MaxStorageBytes > 0 {
+			usage, err := storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			if usage > usageBefore && usage > cfg.MaxStorageBytes {
+				return ErrStorageQuotaExceeded
+			}
+		}
+
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
+		}
+
+		listCache.Remove(listCacheKey{userID: userID, listID: listID})
+
+		return c.JSON(status, ListResponse(record))
+	})
+
+	api.PATCH("/list/:list_id", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		var params ListPartialRequest
+
+		if err := mergepatch.Bind(c, &params); err != nil {
+			return err
+		}
+
+		if !params.Title.Present && !params.Description.Present && !params.IsPinned.Present && !params.Category.Present {
+			return ErrEmptyListPatch
+		}
+
+		if params.Title.Null {
+			return nullField("title")
+		}
+
+		if params.IsPinned.Null {
+			return nullField("is_pinned")
+		}
+
+		if params.Category.Null {
+			return nullField("category")
+		}
+
+		if params.Title.Present {
+			if err := checkTitle(params.Title.Value); err != nil {
+				return err
+			}
+		}
+
+		if params.Category.Present && !slices.Contains(listCategories, params.Category.Value) {
+			return ErrInvalidCategory
+		}
+
+		version, hasVersion, err := ifMatchVersion(c)
+
+		if err != nil {
+			return err
+		}
+
+		// SET replaces any earlier assignments, so they are collected and set
+		// once the request has been read.
+		assignments := []any{
+			todo.Lists.UpdatedAt.SET(pg.NOW()),
+			todo.Lists.Version.SET(todo.Lists.Version.ADD(pg.Int(1))),
+		}
+		changed := pg.BoolExp(pg.Bool(false))
+
+		stmt := todo.Lists.
+			UPDATE().
+			RETURNING(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+			)
+
+		if params.Title.Present {
+			assignments = append(assignments, todo.Lists.Title.SET(pg.String(params.Title.Value)))
+			changed = changed.OR(todo.Lists.Title.IS_DISTINCT_FROM(pg.String(params.Title.Value)))
+		}
+
+		// The description column isn't nullable, so null clears it to the
+		// empty string. Value is already "" in that case.
+		if params.Description.Present {
+			assignments = append(assignments, todo.Lists.Description.SET(pg.String(params.Description.Value)))
+			changed = changed.OR(todo.Lists.Description.IS_DISTINCT_FROM(pg.String(params.Description.Value)))
+		}
+
+		if params.IsPinned.Present {
+			assignments = append(assignments, todo.Lists.IsPinned.SET(pg.Bool(params.IsPinned.Value)))
+			changed = changed.OR(todo.Lists.IsPinned.IS_DISTINCT_FROM(pg.Bool(params.IsPinned.Value)))
+		}
+
+		if params.Category.Present {
+			assignments = append(assignments, todo.Lists.Category.SET(pg.String(params.Category.Value)))
+			changed = changed.OR(todo.Lists.Category.IS_DISTINCT_FROM(pg.String(params.Category.Value)))
+		}
+
+		condition := todo.Lists.ListID.EQ(pg.Int(listID)).
+			AND(todo.Lists.UserID.EQ(pg.String(userID))).
+			AND(todo.Lists.DeletedAt.IS_NULL())
+
+		if hasVersion {
+			condition = condition.AND(todo.Lists.Version.EQ(pg.Int(version)))
+		}
+
+		skipUnchanged := prefersUnchanged(c)
+
+		// With "Prefer: unchanged" rows that already hold the requested values
+		// are left alone.
+		if skipUnchanged {
+			stmt = stmt.WHERE(condition.AND(changed))
+		} else {
+			stmt = stmt.WHERE(condition)
+		}
+
+		query, args := stmt.SET(assignments[0], assignments[1:]...).Sql()
+
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		var usageBefore int64
+
+		if cfg.MaxStorageBytes > 0 {
+			usageBefore, err = storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+		}
+
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+
+		// Nothing was updated, either the list doesn't exist or it already
+		// has the requested values.
+		if errors.Is(err, pgx.ErrNoRows) && skipUnchanged {
+			query, args := pg.SELECT(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+			).
+				FROM(todo.Lists).
+				WHERE(condition).
+				Sql()
+
+			rows, _ := tx.Query(c.Request().Context(), query, args...)
+			record, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+
+			if err == nil {
+				c.Response().Header().Set("Preference-Applied", "unchanged")
+				return c.JSON(http.StatusOK, ListResponse(record))
+			}
+		}
+
+		if errors.Is(err, pgx.ErrNoRows) && hasVersion {
+			exists, err := listExists(c.Request().Context(), tx, userID, listID)
+
+			if err != nil {
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+
+			if exists {
+				return ErrVersionMismatch
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error updating list", "err", err)
+			return dbError(err)
+		}
+
+		if cfg.MaxStorageBytes > 0 {
+			usage, err := storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			if usage > usageBefore && usage > cfg.MaxStorageBytes {
+				return ErrStorageQuotaExceeded
+			}
+		}
+
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
+		}
+
+		listCache.Remove(listCacheKey{userID: userID, listID: listID})
+
+		return c.JSON(http.StatusOK, ListResponse(record))
+	})
+
+	api.DELETE("/list/:list_id", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		version, hasVersion, err := ifMatchVersion(c)
+
+		if err != nil {
+			return err
+		}
+
+		condition := todo.Lists.ListID.EQ(pg.Int(listID)).
+			AND(todo.Lists.UserID.EQ(pg.String(userID))).
+			AND(todo.Lists.DeletedAt.IS_NULL())
+
+		if hasVersion {
+			condition = condition.AND(todo.Lists.Version.EQ(pg.Int(version)))
+		}
+
+		// Lists are soft deleted so they can be restored with
+		// POST /list/:list_id/restore.
+		query, args := todo.Lists.
+			UPDATE().
+			SET(todo.Lists.DeletedAt.SET(pg.NOW())).
+			WHERE(condition).
+			Sql()
+
+		tag, err := db.Exec(c.Request().Context(), query, args...)
+
+		if err != nil {
+			requestLogger(c).Error("Error deleting list", "err", err)
+			return dbError(err)
+		}
+
+		if tag.RowsAffected() == 0 {
+			if hasVersion {
+				exists, err := listExists(c.Request().Context(), db, userID, listID)
+
+				if err != nil {
+					requestLogger(c).Error("Error checking if list exists", "err", err)
+					return dbError(err)
+				}
+
+				if exists {
+					return ErrVersionMismatch
+				}
+			}
+
+			return ErrNotFound
+		}
+
+		listCache.Remove(listCacheKey{userID: userID, listID: listID})
+
+		return deleted(c)
+	})
+
+	api.POST("/list/:list_id/restore", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		query, args := todo.Lists.
+			UPDATE().
+			SET(
+				todo.Lists.DeletedAt.SET(pg.TimestampzExp(pg.NULL)),
+				todo.Lists.UpdatedAt.SET(pg.NOW()),
+				todo.Lists.Version.SET(todo.Lists.Version.ADD(pg.Int(1))),
+			).
+			WHERE(
+				todo.Lists.ListID.EQ(pg.Int(listID)).
+					AND(todo.Lists.UserID.EQ(pg.String(userID))).
+					AND(todo.Lists.DeletedAt.IS_NOT_NULL()),
+			).
+			RETURNING(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+			).
+			Sql()
+
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error restoring list", "err", err)
+			return dbError(err)
+		}
+
+		// A list in the trash doesn't count towards storage, so restoring
+		// one grows usage.
+		if cfg.MaxStorageBytes > 0 {
+			usage, err := storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			if usage > cfg.MaxStorageBytes {
+				return ErrStorageQuotaExceeded
+			}
+		}
+
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
+		}
+
+		return c.JSON(http.StatusOK, ListResponse(record))
+	})
+
+	// completeAll marks every item in a list as complete or incomplete.
+	completeAll := func(isComplete bool) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID := c.Get("userID").(string)
+			var listID int64
+
+			if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+				return err
+			}
+
+			{
+				query, args := pg.SELECT(pg.Int64(1)).
+					FROM(todo.Lists).
+					WHERE(
+						todo.Lists.ListID.EQ(pg.Int(listID)).
+							AND(todo.Lists.UserID.EQ(pg.String(userID))).
+							AND(todo.Lists.DeletedAt.IS_NULL()),
+					).
+					Sql()
+
+				rows, _ := db.Query(c.Request().Context(), query, args...)
+				_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+				if err != nil {
+					if errors.Is(err, pgx.ErrNoRows) {
+						return ErrNotFound
+					}
+					requestLogger(c).Error("Error checking if list exists", "err", err)
+					return dbError(err)
+				}
+			}
+
+			query, args := todo.Items.
+				UPDATE().
+				SET(
+					todo.Items.IsComplete.SET(pg.Bool(isComplete)),
+					todo.Items.CompletedAt.SET(completedAt(pg.Bool(isComplete))),
+					todo.Items.UpdatedAt.SET(pg.NOW()),
+					todo.Items.Version.SET(todo.Items.Version.ADD(pg.Int(1))),
+				).
+				WHERE(
+					todo.Items.ListID.EQ(pg.Int(listID)).
+						AND(todo.Items.IsComplete.NOT_EQ(pg.Bool(isComplete))),
+				).
+				Sql()
+
+			tag, err := db.Exec(c.Request().Context(), query, args...)
+
+			if err != nil {
+				requestLogger(c).Error("Error updating items", "err", err)
+				return dbError(err)
+			}
+
+			return c.JSON(http.StatusOK, UpdatedResponse{Updated: tag.RowsAffected()})
+		}
+	}
+
+	api.POST("/list/:list_id/complete-all", completeAll(true))
+	api.POST("/list/:list_id/uncomplete-all", completeAll(false))
+
+	api.DELETE("/list/:list_id/completed", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		{
+			query, args := pg.SELECT(pg.Int64(1)).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				Sql()
+
+			rows, _ := db.Query(c.Request().Context(), query, args...)
+			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+		}
+
+		query, args := todo.Items.
+			DELETE().
+			WHERE(
+				todo.Items.ListID.EQ(pg.Int(listID)).
+					AND(todo.Items.IsComplete.IS_TRUE()),
+			).
+			Sql()
+
+		tag, err := db.Exec(c.Request().Context(), query, args...)
+
+		if err != nil {
+			requestLogger(c).Error("Error deleting items", "err", err)
+			return dbError(err)
+		}
+
+		return c.JSON(http.StatusOK, DeletedResponse{Deleted: tag.RowsAffected()})
+	})
+
+	api.POST("/list/:list_id/duplicate", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		var usageBefore int64
+
+		if cfg.MaxStorageBytes > 0 {
+			usageBefore, err = storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+		}
+
+		sourceCondition := todo.Lists.ListID.EQ(pg.Int(listID)).
+			AND(todo.Lists.UserID.EQ(pg.String(userID))).
+			AND(todo.Lists.DeletedAt.IS_NULL())
+
+		query, args := pg.SELECT(todo.Lists.Title).FROM(todo.Lists).WHERE(sourceCondition).Sql()
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		sourceTitle, err := pgx.CollectOneRow(rows, pgx.RowTo[string])
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error fetching list", "err", err)
+			return dbError(err)
+		}
+
+		// Only titles of earlier copies can clash with the new one.
+		query, args = pg.SELECT(pg.LOWER(todo.Lists.Title)).
+			FROM(todo.Lists).
+			WHERE(
+				todo.Lists.UserID.EQ(pg.String(userID)).
+					AND(todo.Lists.DeletedAt.IS_NULL()).
+					AND(pg.LOWER(todo.Lists.Title).LIKE(pg.String("% (copy%)"))),
+			).
+			Sql()
+
+		rows, _ = tx.Query(c.Request().Context(), query, args...)
+		titles, err := pgx.CollectRows(rows, pgx.RowTo[string])
+
+		if err != nil {
+			requestLogger(c).Error("Error fetching list titles", "err", err)
+			return dbError(err)
+		}
+
+		taken := make(map[string]bool, len(titles))
+
+		for _, title := range titles {
+			taken[title] = true
+		}
+
+		// Only the source list's owner gets a row back, so a missing or
+		// someone else's list copies nothing.
+		query, args = todo.Lists.INSERT(
+			todo.Lists.Title,
+			todo.Lists.Description,
+			todo.Lists.IsPinned,
+			todo.Lists.Category,
+			todo.Lists.UserID,
+		).
+			QUERY(
+				pg.SELECT(
+					pg.String(copyTitle(sourceTitle, taken)),
+					todo.Lists.Description,
+					todo.Lists.IsPinned,
+					todo.Lists.Category,
+					todo.Lists.UserID,
+				).
+					FROM(todo.Lists).
+					WHERE(sourceCondition),
+			).
+			RETURNING(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+			).
+			Sql()
+
+		rows, _ = tx.Query(c.Request().Context(), query, args...)
+		listRecord, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error copying list", "err", err)
+			return dbError(err)
+		}
+
+		// Dependencies point at the source list's items, so they aren't
+		// carried over to the copies.
+		{
+			query, args := todo.Items.INSERT(
+				todo.Items.ListID,
+				todo.Items.Content,
+				todo.Items.IsComplete,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+			).
+				QUERY(
+					pg.SELECT(
+						pg.Int(listRecord.ListID),
+						todo.Items.Content,
+						pg.Bool(false),
+						todo.Items.Position,
+						todo.Items.DueDate,
+						todo.Items.Priority,
+					).
+						FROM(todo.Items).
+						WHERE(todo.Items.ListID.EQ(pg.Int(listID))),
+				).
+				Sql()
+
+			if _, err := tx.Exec(c.Request().Context(), query, args...); err != nil {
+				requestLogger(c).Error("Error copying items", "err", err)
+				return dbError(err)
+			}
+		}
+
+		if cfg.MaxStorageBytes > 0 {
+			usage, err := storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			if usage > usageBefore && usage > cfg.MaxStorageBytes {
+				return ErrStorageQuotaExceeded
+			}
+		}
+
+		query, args = pg.SELECT(
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
+		).
+			FROM(todo.Items).
+			WHERE(todo.Items.ListID.EQ(pg.Int(listRecord.ListID))).
+			ORDER_BY(todo.Items.Position, todo.Items.ItemID).
+			Sql()
+
+		rows, _ = tx.Query(c.Request().Context(), query, args...)
+		itemRecords, err := pgx.CollectRows(rows, pgx.RowToStructByName[ItemsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error fetching items", "err", err)
+			return dbError(err)
+		}
+
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
+		}
+
+		list := ListWithItemsResponse{
+			ListResponse: ListResponse(listRecord),
+			Items:        make([]ItemResponse, 0, len(itemRecords)),
+		}
+
+		for _, record := range itemRecords {
+			list.Items = append(list.Items, ItemResponse(record))
+		}
+
+		return c.JSON(http.StatusCreated, list)
+	})
+
+	api.GET("/list/:list_id/digest", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		{
+			query, args := pg.SELECT(pg.Int64(1)).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				Sql()
+
+			rows, _ := db.Query(c.Request().Context(), query, args...)
+			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+		}
+
+		query, args := pg.SELECT(
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.Position,
+		).
+			FROM(todo.Items).
+			WHERE(todo.Items.ListID.EQ(pg.Int(listID))).
+			ORDER_BY(todo.Items.Position, todo.Items.ItemID).
+			Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ItemDigestRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error fetching items", "err", err)
+			return dbError(err)
+		}
+
+		// encoding/json writes struct fields in order, so equal items always
+		// hash the same.
+		body, err := json.Marshal(records)
+
+		if err != nil {
+			requestLogger(c).Error("Error encoding items", "err", err)
+			return ErrInternalServerError
+		}
+
+		digest := sha256.Sum256(body)
+
+		return c.JSON(http.StatusOK, DigestResponse{Digest: hex.EncodeToString(digest[:])})
+	})
+
+	if flags.Enabled("merge") {
+		api.POST("/list/:list_id/merge", func(c echo.Context) error {
+			userID := c.Get("userID").(string)
+			var listID int64
+
+			if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+				return err
+			}
+
+			var params MergeRequest
+
+			if err := c.Bind(&params); err != nil {
+				return err
+			}
+
+			if params.SourceListID == listID {
+				return ErrMergeSameList
+			}
+
+			tx, err := db.Begin(c.Request().Context())
+
+			if err != nil {
+				requestLogger(c).Error("Error starting transaction", "err", err)
+				return dbError(err)
+			}
+
+			defer tx.Rollback(c.Request().Context())
+
+			{
+				query, args := pg.SELECT(todo.Lists.ListID).
+					FROM(todo.Lists).
+					WHERE(
+						todo.Lists.ListID.IN(pg.Int(listID), pg.Int(params.SourceListID)).
+							AND(todo.Lists.UserID.EQ(pg.String(userID))).
+							AND(todo.Lists.DeletedAt.IS_NULL()),
+					).
+					FOR(pg.UPDATE()).
+					Sql()
+
+				rows, _ := tx.Query(c.Request().Context(), query, args...)
+				listIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+
+				if err != nil {
+					requestLogger(c).Error("Error checking if lists exist", "err", err)
+					return dbError(err)
+				}
+
+				if len(listIDs) != 2 {
+					return ErrNotFound
+				}
+			}
+
+			{
+				query, args := todo.Items.
+					UPDATE().
+					SET(
+						todo.Items.ListID.SET(pg.Int(listID)),
+						todo.Items.Position.SET(todo.Items.Position.ADD(nextItemPosition(listID)).SUB(pg.Int(1))),
+						todo.Items.UpdatedAt.SET(pg.NOW()),
+						todo.Items.Version.SET(todo.Items.Version.ADD(pg.Int(1))),
+					).
+					WHERE(todo.Items.ListID.EQ(pg.Int(params.SourceListID))).
+					Sql()
+
+				if _, err := tx.Exec(c.Request().Context(), query, args...); err != nil {
+					requestLogger(c).Error("Error moving items", "err", err)
+					return dbError(err)
+				}
+			}
+
+			if params.DeleteSource {
+				query, args := todo.Lists.
+					UPDATE().
+					SET(todo.Lists.DeletedAt.SET(pg.NOW())).
+					WHERE(todo.Lists.ListID.EQ(pg.Int(params.SourceListID))).
+					Sql()
+
+				if _, err := tx.Exec(c.Request().Context(), query, args...); err != nil {
+					requestLogger(c).Error("Error deleting list", "err", err)
+					return dbError(err)
+				}
+			}
+
+			query, args := pg.SELECT(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+			).
+				FROM(todo.Lists).
+				WHERE(todo.Lists.ListID.EQ(pg.Int(listID))).
+				Sql()
+
+			rows, _ := tx.Query(c.Request().Context(), query, args...)
+			listRecord, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+
+			if err != nil {
+				requestLogger(c).Error("Error fetching list", "err", err)
+				return dbError(err)
+			}
+
+			query, args = pg.SELECT(
+				todo.Items.ItemID,
+				todo.Items.Content,
+				todo.Items.IsComplete,
+				todo.Items.BlockedByItemID,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+				todo.Items.CompletedAt,
+				todo.Items.Version,
+				todo.Items.CreatedAt,
+				todo.Items.UpdatedAt,
+			).
+				FROM(todo.Items).
+				WHERE(todo.Items.ListID.EQ(pg.Int(listID))).
+				ORDER_BY(todo.Items.Position, todo.Items.ItemID).
+				Sql()
+
+			rows, _ = tx.Query(c.Request().Context(), query, args...)
+			itemRecords, err := pgx.CollectRows(rows, pgx.RowToStructByName[ItemsRecord])
+
+			if err != nil {
+				requestLogger(c).Error("Error fetching items", "err", err)
+				return dbError(err)
+			}
+
+			if err := tx.Commit(c.Request().Context()); err != nil {
+				requestLogger(c).Error("Error committing transaction", "err", err)
+				return dbError(err)
+			}
+
+			listCache.Remove(listCacheKey{userID: userID, listID: listID})
+			listCache.Remove(listCacheKey{userID: userID, listID: params.SourceListID})
+
+			list := ListWithItemsResponse{
+				ListResponse: ListResponse(listRecord),
+				Items:        make([]ItemResponse, 0, len(itemRecords)),
+			}
+
+			for _, record := range itemRecords {
+				list.Items = append(list.Items, ItemResponse(record))
+			}
+
+			return c.JSON(http.StatusOK, list)
+		})
+	}
+
+	api.GET("/list/:list_id/item", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		var unblocked bool
+		after, limit := int64(0), int64(defaultListLimit)
+
+		if err := echo.QueryParamsBinder(c).Bool("unblocked", &unblocked).Int64("after", &after).Int64("limit", &limit).BindError(); err != nil {
+			return err
+		}
+
+		// Pages are keyed on item_id rather than offset so they stay stable
+		// while items are added and removed.
+		paginated := c.QueryParam("after") != "" || c.QueryParam("limit") != ""
+
+		if paginated && (limit < 1 || limit > maxListLimit) {
+			return ErrInvalidLimit
+		}
+
+		if paginated && c.QueryParam("sort") != "" {
+			return ErrSortWithCursor
+		}
+
+		orderBy := []pg.OrderByClause{todo.Items.Position.ASC(), todo.Items.ItemID.ASC()}
+
+		if paginated {
+			orderBy = []pg.OrderByClause{todo.Items.ItemID.ASC()}
+		}
+
+		if sort := c.QueryParam("sort"); sort != "" {
+			var err error
+			orderBy, err = parseSort(sort, itemSortColumns, todo.Items.ItemID)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		var dueBefore *time.Time
+
+		if value := c.QueryParam("due_before"); value != "" {
+			before, err := time.Parse(time.RFC3339, value)
+
+			if err != nil {
+				return ErrInvalidDueBefore
+			}
+
+			dueBefore = &before
+		}
+
+		priority := c.QueryParam("priority")
+
+		if priority != "" && !slices.Contains(itemPriorities, priority) {
+			return ErrInvalidPriority
+		}
+
+		var isComplete *bool
+
+		if value := c.QueryParam("is_complete"); value != "" {
+			if value != "true" && value != "false" {
+				return ErrInvalidIsComplete
+			}
+
+			complete := value == "true"
+			isComplete = &complete
+		}
+
+		// Joining lists enforces ownership in the same query that fetches the
+		// items.
+		var from pg.ReadableTable = todo.Items.INNER_JOIN(todo.Lists, todo.Lists.ListID.EQ(todo.Items.ListID))
+		condition := todo.Items.ListID.EQ(pg.Int(listID)).
+			AND(todo.Lists.UserID.EQ(pg.String(userID))).
+			AND(todo.Lists.DeletedAt.IS_NULL())
+
+		if isComplete != nil {
+			condition = condition.AND(todo.Items.IsComplete.EQ(pg.Bool(*isComplete)))
+		}
+
+		if dueBefore != nil {
+			condition = condition.AND(todo.Items.DueDate.LT(pg.TimestampzT(*dueBefore)))
+		}
+
+		if priority != "" {
+			condition = condition.AND(todo.Items.Priority.EQ(pg.String(priority)))
+		}
+
+		if paginated {
+			condition = condition.AND(todo.Items.ItemID.GT(pg.Int(after)))
+		}
+
+		if unblocked {
+			blockers := todo.Items.AS("blockers")
+			from = from.LEFT_JOIN(blockers, blockers.ItemID.EQ(todo.Items.BlockedByItemID))
+			condition = condition.AND(
+				todo.Items.BlockedByItemID.IS_NULL().OR(blockers.IsComplete.IS_TRUE()),
+			)
+		}
+
+		stmt := pg.SELECT(
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
+		).
+			FROM(from).
+			WHERE(condition).
+			ORDER_BY(orderBy...)
+
+		// One row past the page tells whether there is another page.
+		if paginated {
+			stmt = stmt.LIMIT(limit + 1)
+		}
+
+		query, args := stmt.Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ItemsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error fetching items", "err", err)
+			return dbError(err)
+		}
+
+		// No rows can also mean the list is empty, only then is it worth a
+		// second query to tell that apart from a list the user doesn't own.
+		if len(records) == 0 {
+			query, args := pg.SELECT(pg.Int64(1)).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				Sql()
+
+			rows, _ := db.Query(c.Request().Context(), query, args...)
+			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+		}
+
+		var nextCursor *int64
+
+		if paginated && int64(len(records)) > limit {
+			records = records[:limit]
+			nextCursor = &records[limit-1].ItemID
+		}
+
+		var items = make([]ItemResponse, 0, len(records))
+
+		for _, record := range records {
+			items = append(items, ItemResponse(record))
+		}
+
+		if paginated {
+			return c.JSON(http.StatusOK, ItemPageResponse{Items: items, NextCursor: nextCursor})
+		}
+
+		return c.JSON(http.StatusOK, items)
+	})
+
+	api.GET("/list/:list_id/item/next", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		{
+			query, args := pg.SELECT(pg.Int64(1)).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				Sql()
+
+			rows, _ := db.Query(c.Request().Context(), query, args...)
+			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+		}
+
+		query, args := pg.SELECT(
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
+		).
+			FROM(todo.Items).
+			WHERE(
+				todo.Items.ListID.EQ(pg.Int(listID)).
+					AND(todo.Items.IsComplete.IS_FALSE()),
+			).
+			ORDER_BY(todo.Items.Position, todo.Items.ItemID).
+			LIMIT(1).
+			Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error fetching next item", "err", err)
+			return dbError(err)
+		}
+
+		return c.JSON(http.StatusOK, ItemResponse(record))
+	})
+
+	api.GET("/list/:list_id/item/:item_id", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID, itemID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
+			return err
+		}
+
+		query, args := pg.SELECT(
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
+		).
+			FROM(todo.Items.INNER_JOIN(todo.Lists, todo.Lists.ListID.EQ(todo.Items.ListID))).
+			WHERE(
+				todo.Items.ItemID.EQ(pg.Int(itemID)).
+					AND(todo.Items.ListID.EQ(pg.Int(listID))).
+					AND(todo.Lists.UserID.EQ(pg.String(userID))).
+					AND(todo.Lists.DeletedAt.IS_NULL()),
+			).
+			Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error fetching item", "err", err)
+			return dbError(err)
+		}
+
+		if notModified(c, record.UpdatedAt) {
+			return c.NoContent(http.StatusNotModified)
+		}
+
+		return c.JSON(http.StatusOK, ItemResponse(record))
+	})
+
+	api.POST("/list/:list_id/item", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		var params ItemRequest
+
+		if err := c.Bind(&params); err != nil {
+			return err
+		}
+
+		params.Content = strings.TrimSpace(params.Content)
+
+		if err := checkContent(params.Content); err != nil {
+			return err
+		}
+
+		if params.Priority == "" {
+			params.Priority = "medium"
+		}
+
+		if !slices.Contains(itemPriorities, params.Priority) {
+			return ErrInvalidPriority
+		}
+
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		// The list row is locked so it can't be deleted before the item is
+		// inserted, and so concurrent inserts don't get the same position.
+		{
+			query, args := pg.SELECT(pg.Int64(1)).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				FOR(pg.UPDATE()).
+				Sql()
+
+			rows, _ := tx.Query(c.Request().Context(), query, args...)
+			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+		}
+
+		if params.BlockedByItemID != nil {
+			chain, err := blockerChain(c.Request().Context(), tx, listID, *params.BlockedByItemID)
+
+			if err != nil {
+				requestLogger(c).Error("Error checking blocking item", "err", err)
+				return dbError(err)
+			}
+
+			if len(chain) == 0 {
+				return ErrInvalidBlocker
+			}
+
+			// MaxDependencyDepth counts links. The new item links to the
+			// first item in the chain and each of those to the next, so
+			// there is one link per item in the chain.
+			if cfg.MaxDependencyDepth > 0 && int64(len(chain)) > cfg.MaxDependencyDepth {
+				return ErrDependencyTooDeep
+			}
+		}
+
+		if cfg.MaxStorageBytes > 0 {
+			usage, err := storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			if usage+int64(len(params.Content)) > cfg.MaxStorageBytes {
+				return ErrStorageQuotaExceeded
+			}
+		}
+
+		query, args := todo.Items.
+			INSERT(
+				todo.Items.Content,
+				todo.Items.IsComplete,
+				todo.Items.CompletedAt,
+				todo.Items.ListID,
+				todo.Items.BlockedByItemID,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+			).
+			VALUES(
+				params.Content,
+				params.IsComplete,
+				newCompletedAt(params.IsComplete),
+				listID,
+				params.BlockedByItemID,
+				nextItemPosition(listID),
+				params.DueDate,
+				params.Priority,
+			).
+			RETURNING(
+				todo.Items.ItemID,
+				todo.Items.Content,
+				todo.Items.IsComplete,
+				todo.Items.BlockedByItemID,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+				todo.Items.CompletedAt,
+				todo.Items.Version,
+				todo.Items.CreatedAt,
+				todo.Items.UpdatedAt,
+			).
+			Sql()
+
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error creating item", "err", err)
+			return dbError(err)
+		}
+
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
+		}
+
+		return c.JSON(http.StatusCreated, ItemResponse(record))
+	})
+
+	api.POST("/list/:list_id/item/batch", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+			return err
+		}
+
+		var params ItemBatchRequest
+
+		if err := c.Bind(&params); err != nil {
+			return err
+		}
+
+		if len(params.Items) == 0 || len(params.Items) > maxBatchItems {
+			return ErrInvalidBatchSize
+		}
+
+		var contentBytes int64
+
+		for i := range params.Items {
+			item := &params.Items[i]
+			item.Content = strings.TrimSpace(item.Content)
+
+			if err := checkContent(item.Content); err != nil {
+				return problem.Extend(err, map[string]any{"index": i})
+			}
+
+			if item.Priority == "" {
+				item.Priority = "medium"
+			}
+
+			if !slices.Contains(itemPriorities, item.Priority) {
+				return problem.Extend(ErrInvalidPriority, map[string]any{"index": i})
+			}
+
+			contentBytes += int64(len(item.Content))
+		}
+
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		// The list row is locked so it can't be deleted before the items are
+		// inserted, and so concurrent inserts don't get the same positions.
+		{
+			query, args := pg.SELECT(pg.Int64(1)).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				FOR(pg.UPDATE()).
+				Sql()
+
+			rows, _ := tx.Query(c.Request().Context(), query, args...)
+			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+		}
+
+		for i, item := range params.Items {
+			if item.BlockedByItemID == nil {
+				continue
+			}
+
+			chain, err := blockerChain(c.Request().Context(), tx, listID, *item.BlockedByItemID)
+
+			if err != nil {
+				requestLogger(c).Error("Error checking blocking item", "err", err)
+				return dbError(err)
+			}
+
+			if len(chain) == 0 {
+				return problem.Extend(ErrInvalidBlocker, map[string]any{"index": i})
+			}
+
+			if cfg.MaxDependencyDepth > 0 && int64(len(chain)) > cfg.MaxDependencyDepth {
+				return problem.Extend(ErrDependencyTooDeep, map[string]any{"index": i})
+			}
+		}
+
+		if cfg.MaxStorageBytes > 0 {
+			usage, err := storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			if usage+contentBytes > cfg.MaxStorageBytes {
+				return ErrStorageQuotaExceeded
+			}
+		}
+
+		stmt := todo.Items.INSERT(
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.CompletedAt,
+			todo.Items.ListID,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+		)
+
+		// Every row sees the list as it was before the insert, so positions are
+		// offset from the same starting point.
+		for n, item := range params.Items {
+			stmt = stmt.VALUES(
+				item.Content,
+				item.IsComplete,
+				newCompletedAt(item.IsComplete),
+				listID,
+				item.BlockedByItemID,
+				nextItemPosition(listID).ADD(pg.Int(int64(n))),
+				item.DueDate,
+				item.Priority,
+			)
+		}
+
+		query, args := stmt.RETURNING(
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
+		).Sql()
+
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ItemsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error creating items", "err", err)
+			return dbError(err)
+		}
+
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
+		}
+
+		items := make([]ItemResponse, 0, len(records))
+
+		for _, record := range records {
+			items = append(items, ItemResponse(record))
+		}
+
+		return c.JSON(http.StatusCreated, items)
+	})
+
+	api.PUT("/list/:list_id/item/:item_id", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID, itemID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
+			return err
+		}
+
+		var params ItemRequest
+
+		if err := c.Bind(&params); err != nil {
+			return err
+		}
+
+		params.Content = strings.TrimSpace(params.Content)
+
+		if err := checkContent(params.Content); err != nil {
+			return err
+		}
+
+		version, hasVersion, err := ifMatchVersion(c)
+
+		if err != nil {
+			return err
+		}
+
+		if params.Priority == "" {
+			params.Priority = "medium"
+		}
+
+		if !slices.Contains(itemPriorities, params.Priority) {
+			return ErrInvalidPriority
+		}
+
+		{
+			query, args := pg.SELECT(pg.Int64(1)).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				Sql()
+
+			rows, _ := db.Query(c.Request().Context(), query, args...)
+			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+		}
+
+		if params.BlockedByItemID != nil {
+			if *params.BlockedByItemID == itemID {
+				return ErrSelfBlocked
+			}
+
+			chain, err := blockerChain(c.Request().Context(), db, listID, *params.BlockedByItemID)
+
+			if err != nil {
+				requestLogger(c).Error("Error checking blocking item", "err", err)
+				return dbError(err)
+			}
+
+			if len(chain) == 0 {
+				return ErrInvalidBlocker
+			}
+
+			if slices.Contains(chain, itemID) {
+				return ErrBlockedCycle
+			}
+
+			// Items already blocked by this one get pushed further down the
+			// chain too.
+			if cfg.MaxDependencyDepth > 0 {
+				rows, _ := db.Query(c.Request().Context(), dependentDepth, itemID)
+				depth, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+				if err != nil {
+					requestLogger(c).Error("Error checking dependent items", "err", err)
+					return dbError(err)
+				}
+
+				if int64(len(chain))+depth > cfg.MaxDependencyDepth {
+					return ErrDependencyTooDeep
+				}
+			}
+		}
+
+		blockedByItemID := pg.IntExp(pg.NULL)
+
+		if params.BlockedByItemID != nil {
+			blockedByItemID = pg.Int(*params.BlockedByItemID)
+		}
+
+		dueDate := pg.TimestampzExp(pg.NULL)
+
+		if params.DueDate != nil {
+			dueDate = pg.TimestampzT(*params.DueDate)
+		}
+
+		condition := todo.Items.ItemID.EQ(pg.Int(itemID)).
+			AND(todo.Items.ListID.EQ(pg.Int(listID)))
+
+		if hasVersion {
+			condition = condition.AND(todo.Items.Version.EQ(pg.Int(version)))
+		}
+
+		query, args := todo.Items.
+			UPDATE().
+			SET(
+				todo.Items.Content.SET(pg.String(params.Content)),
+				todo.Items.IsComplete.SET(pg.Bool(params.IsComplete)),
+				todo.Items.CompletedAt.SET(completedAt(pg.Bool(params.IsComplete))),
+				todo.Items.BlockedByItemID.SET(blockedByItemID),
+				todo.Items.DueDate.SET(dueDate),
+				todo.Items.Priority.SET(pg.String(params.Priority)),
+				todo.Items.UpdatedAt.SET(pg.NOW()),
+				todo.Items.Version.SET(todo.Items.Version.ADD(pg.Int(1))),
+			).
+			WHERE(condition).
+			RETURNING(
+				todo.Items.ItemID,
+				todo.Items.Content,
+				todo.Items.IsComplete,
+				todo.Items.BlockedByItemID,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+				todo.Items.CompletedAt,
+				todo.Items.Version,
+				todo.Items.CreatedAt,
+				todo.Items.UpdatedAt,
+			).Sql()
+
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		var usageBefore int64
+
+		if cfg.MaxStorageBytes > 0 {
+			usageBefore, err = storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+		}
+
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+
+		if errors.Is(err, pgx.ErrNoRows) && hasVersion {
+			exists, err := itemExists(c.Request().Context(), tx, userID, listID, itemID)
+
+			if err != nil {
+				requestLogger(c).Error("Error checking if item exists", "err", err)
+				return dbError(err)
+			}
+
+			if exists {
+				return ErrVersionMismatch
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error updating item", "err", err)
+			return dbError(err)
+		}
+
+		if cfg.MaxStorageBytes > 0 {
+			usage, err := storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			if usage > usageBefore && usage > cfg.MaxStorageBytes {
+				return ErrStorageQuotaExceeded
+			}
+		}
+
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
+		}
+
+		return c.JSON(http.StatusOK, ItemResponse(record))
+	})
+
+	api.PATCH("/list/:list_id/item/:item_id", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID, itemID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
+			return err
+		}
+
+		var params ItemPartialRequest
+
+		if err := mergepatch.Bind(c, &params); err != nil {
+			return err
+		}
+
+		if !params.Content.Present && !params.IsComplete.Present && !params.BlockedByItemID.Present && !params.DueDate.Present && !params.Priority.Present {
+			return ErrEmptyItemPatch
+		}
+
+		if params.Content.Null {
+			return nullField("content")
+		}
+
+		if params.IsComplete.Null {
+			return nullField("is_complete")
+		}
+
+		if params.Priority.Null {
+			return nullField("priority")
+		}
+
+		if params.Content.Present {
+			params.Content.Value = strings.TrimSpace(params.Content.Value)
+
+			if err := checkContent(params.Content.Value); err != nil {
+				return err
+			}
+		}
+
+		if params.Priority.Present && !slices.Contains(itemPriorities, params.Priority.Value) {
+			return ErrInvalidPriority
+		}
+
+		version, hasVersion, err := ifMatchVersion(c)
+
+		if err != nil {
+			return err
+		}
+
+		{
+			query, args := pg.SELECT(pg.Int64(1)).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				Sql()
+
+			rows, _ := db.Query(c.Request().Context(), query, args...)
+			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+		}
+
+		if params.BlockedByItemID.Set() {
+			if params.BlockedByItemID.Value == itemID {
+				return ErrSelfBlocked
+			}
+
+			chain, err := blockerChain(c.Request().Context(), db, listID, params.BlockedByItemID.Value)
+
+			if err != nil {
+				requestLogger(c).Error("Error checking blocking item", "err", err)
+				return dbError(err)
+			}
+
+			if len(chain) == 0 {
+				return ErrInvalidBlocker
+			}
+
+			if slices.Contains(chain, itemID) {
+				return ErrBlockedCycle
+			}
+
+			// Items already blocked by this one get pushed further down the
+			// chain too.
+			if cfg.MaxDependencyDepth > 0 {
+				rows, _ := db.Query(c.Request().Context(), dependentDepth, itemID)
+				depth, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+				if err != nil {
+					requestLogger(c).Error("Error checking dependent items", "err", err)
+					return dbError(err)
+				}
+
+				if int64(len(chain))+depth > cfg.MaxDependencyDepth {
+					return ErrDependencyTooDeep
+				}
+			}
+		}
+
+		// SET replaces any earlier assignments, so they are collected and set
+		// once the request has been read.
+		assignments := []any{
+			todo.Items.UpdatedAt.SET(pg.NOW()),
+			todo.Items.Version.SET(todo.Items.Version.ADD(pg.Int(1))),
+		}
+		changed := pg.BoolExp(pg.Bool(false))
+
+		stmt := todo.Items.
+			UPDATE().
+			RETURNING(
+				todo.Items.ItemID,
+				todo.Items.Content,
+				todo.Items.IsComplete,
+				todo.Items.BlockedByItemID,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+				todo.Items.CompletedAt,
+				todo.Items.Version,
+				todo.Items.CreatedAt,
+				todo.Items.UpdatedAt,
+			)
+
+		if params.Content.Present {
+			assignments = append(assignments, todo.Items.Content.SET(pg.String(params.Content.Value)))
+			changed = changed.OR(todo.Items.Content.IS_DISTINCT_FROM(pg.String(params.Content.Value)))
+		}
+
+		if params.IsComplete.Present {
+			assignments = append(assignments,
+				todo.Items.IsComplete.SET(pg.Bool(params.IsComplete.Value)),
+				todo.Items.CompletedAt.SET(completedAt(pg.Bool(params.IsComplete.Value))),
+			)
+			changed = changed.OR(todo.Items.IsComplete.IS_DISTINCT_FROM(pg.Bool(params.IsComplete.Value)))
+		}
+
+		if params.BlockedByItemID.Null {
+			assignments = append(assignments, todo.Items.BlockedByItemID.SET(pg.IntExp(pg.NULL)))
+			changed = changed.OR(todo.Items.BlockedByItemID.IS_NOT_NULL())
+		} else if params.BlockedByItemID.Present {
+			assignments = append(assignments, todo.Items.BlockedByItemID.SET(pg.Int(params.BlockedByItemID.Value)))
+			changed = changed.OR(todo.Items.BlockedByItemID.IS_DISTINCT_FROM(pg.Int(params.BlockedByItemID.Value)))
+		}
+
+		if params.DueDate.Null {
+			assignments = append(assignments, todo.Items.DueDate.SET(pg.TimestampzExp(pg.NULL)))
+			changed = changed.OR(todo.Items.DueDate.IS_NOT_NULL())
+		} else if params.DueDate.Present {
+			assignments = append(assignments, todo.Items.DueDate.SET(pg.TimestampzT(params.DueDate.Value)))
+			changed = changed.OR(todo.Items.DueDate.IS_DISTINCT_FROM(pg.TimestampzT(params.DueDate.Value)))
+		}
+
+		if params.Priority.Present {
+			assignments = append(assignments, todo.Items.Priority.SET(pg.String(params.Priority.Value)))
+			changed = changed.OR(todo.Items.Priority.IS_DISTINCT_FROM(pg.String(params.Priority.Value)))
+		}
+
+		condition := todo.Items.ItemID.EQ(pg.Int(itemID)).
+			AND(todo.Items.ListID.EQ(pg.Int(listID)))
+
+		if hasVersion {
+			condition = condition.AND(todo.Items.Version.EQ(pg.Int(version)))
+		}
+
+		skipUnchanged := prefersUnchanged(c)
+
+		// With "Prefer: unchanged" rows that already hold the requested values
+		// are left alone.
+		if skipUnchanged {
+			stmt = stmt.WHERE(condition.AND(changed))
+		} else {
+			stmt = stmt.WHERE(condition)
+		}
+
+		query, args := stmt.SET(assignments[0], assignments[1:]...).Sql()
+
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		var usageBefore int64
+
+		if cfg.MaxStorageBytes > 0 {
+			usageBefore, err = storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+		}
+
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+
+		// Nothing was updated, either the item doesn't exist or it already
+		// has the requested values.
+		if errors.Is(err, pgx.ErrNoRows) && skipUnchanged {
+			query, args := pg.SELECT(
+				todo.Items.ItemID,
+				todo.Items.Content,
+				todo.Items.IsComplete,
+				todo.Items.BlockedByItemID,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+				todo.Items.CompletedAt,
+				todo.Items.Version,
+				todo.Items.CreatedAt,
+				todo.Items.UpdatedAt,
+			).
+				FROM(todo.Items).
+				WHERE(condition).
+				Sql()
+
+			rows, _ := tx.Query(c.Request().Context(), query, args...)
+			record, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+
+			if err == nil {
+				c.Response().Header().Set("Preference-Applied", "unchanged")
+				return c.JSON(http.StatusOK, ItemResponse(record))
+			}
+		}
+
+		if errors.Is(err, pgx.ErrNoRows) && hasVersion {
+			exists, err := itemExists(c.Request().Context(), tx, userID, listID, itemID)
+
+			if err != nil {
+				requestLogger(c).Error("Error checking if item exists", "err", err)
+				return dbError(err)
+			}
+
+			if exists {
+				return ErrVersionMismatch
+			}
+		}
+
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrNotFound
+			}
+			requestLogger(c).Error("Error fetching item", "err", err)
+			return dbError(err)
+		}
+
+		if cfg.MaxStorageBytes > 0 {
+			usage, err := storageUsage(c.Request().Context(), tx, userID)
+
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			if usage > usageBefore && usage > cfg.MaxStorageBytes {
+				return ErrStorageQuotaExceeded
+			}
+		}
+
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
+		}
+
+		return c.JSON(http.StatusOK, ItemResponse(record))
+	})
+
+	api.PUT("/list/:list_id/item/:item_id/position", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID, itemID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
+			return err
+		}
+
+		var params PositionRequest
+
+		if err := c.Bind(&params); err != nil {
+			return err
+		}
+
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		// Locking the list row serializes reorders and inserts on the list.
+		{
+			query, args := pg.SELECT(pg.Int64(1)).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.EQ(pg.Int(listID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				FOR(pg.UPDATE()).
+				Sql()
+
+			rows, _ := tx.Query(c.Request().Context(), query, args...)
+			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				if errors.Is(err, pgx.ErrNoRows) {
+					return ErrNotFound
+				}
+				requestLogger(c).Error("Error checking if list exists", "err", err)
+				return dbError(err)
+			}
+		}
+
+		query, args := pg.SELECT(todo.Items.ItemID, todo.Items.Position).
+			FROM(todo.Items).
+			WHERE(todo.Items.ListID.EQ(pg.Int(listID))).
+			ORDER_BY(todo.Items.Position, todo.Items.ItemID).
+			Sql()
+
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		positions, err := pgx.CollectRows(rows, pgx.RowToStructByName[ItemPositionRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error fetching item positions", "err", err)
+			return dbError(err)
+		}
+
+		index := slices.IndexFunc(positions, func(p ItemPositionRecord) bool {
+			return p.ItemID == itemID
+		})
+
+		if index < 0 {
+			return ErrNotFound
+		}
+
+		if params.Position < 1 || params.Position > int64(len(positions)) {
+			return ErrInvalidPosition
+		}
+
+		moved := positions[index]
+		positions = slices.Delete(positions, index, index+1)
+		positions = slices.Insert(positions, int(params.Position-1), moved)
+
+		// Every item is renumbered from 1 so no gaps or duplicates are left
+		// behind, only rows whose position changes are written.
+		var values []string
+		var reorderArgs []any
+
+		for n, item := range positions {
+			if item.Position == int64(n+1) {
+				continue
+			}
+
+			values = append(values, fmt.Sprintf("($%d::bigint, $%d::integer)", len(reorderArgs)+1, len(reorderArgs)+2))
+			reorderArgs = append(reorderArgs, item.ItemID, n+1)
+		}
+
+		if len(values) > 0 {
+			query := fmt.Sprintf(reorderItems, strings.Join(values, ", "))
+
+			if _, err := tx.Exec(c.Request().Context(), query, reorderArgs...); err != nil {
+				requestLogger(c).Error("Error updating item positions", "err", err)
+				return dbError(err)
+			}
+		}
+
+		query, args = pg.SELECT(
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
+		).
+			FROM(todo.Items).
+			WHERE(todo.Items.ItemID.EQ(pg.Int(itemID))).
+			Sql()
+
+		rows, _ = tx.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error fetching item", "err", err)
+			return dbError(err)
+		}
+
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
+		}
+
+		return c.JSON(http.StatusOK, ItemResponse(record))
+	})
+
+	api.POST("/list/:list_id/item/:item_id/move", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		var listID, itemID int64
+
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
+			return err
+		}
+
+		var params MoveRequest
+
+		if err := c.Bind(&params); err != nil {
 			return err
 		}
 
+		tx, err := db.Begin(c.Request().Context())
+
+		if err != nil {
+			requestLogger(c).Error("Error starting transaction", "err", err)
+			return dbError(err)
+		}
+
+		defer tx.Rollback(c.Request().Context())
+
+		{
+			listIDs := []int64{listID}
+
+			if params.TargetListID != listID {
+				listIDs = append(listIDs, params.TargetListID)
+			}
+
+			query, args := pg.SELECT(todo.Lists.ListID).
+				FROM(todo.Lists).
+				WHERE(
+					todo.Lists.ListID.IN(pg.Int(listID), pg.Int(params.TargetListID)).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
+				).
+				FOR(pg.UPDATE()).
+				Sql()
+
+			rows, _ := tx.Query(c.Request().Context(), query, args...)
+			owned, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+
+			if err != nil {
+				requestLogger(c).Error("Error checking if lists exist", "err", err)
+				return dbError(err)
+			}
+
+			if len(owned) != len(listIDs) {
+				return ErrNotFound
+			}
+		}
+
+		if params.TargetListID != listID {
+			// Blockers can't cross lists, so links to and from the moved item
+			// are dropped.
+			query, args := todo.Items.
+				UPDATE().
+				SET(
+					todo.Items.BlockedByItemID.SET(pg.IntExp(pg.NULL)),
+					todo.Items.UpdatedAt.SET(pg.NOW()),
+					todo.Items.Version.SET(todo.Items.Version.ADD(pg.Int(1))),
+				).
+				WHERE(
+					todo.Items.ListID.EQ(pg.Int(listID)).
+						AND(todo.Items.BlockedByItemID.EQ(pg.Int(itemID))),
+				).
+				Sql()
+
+			if _, err := tx.Exec(c.Request().Context(), query, args...); err != nil {
+				requestLogger(c).Error("Error unblocking items", "err", err)
+				return dbError(err)
+			}
+
+			query, args = todo.Items.
+				UPDATE().
+				SET(
+					todo.Items.ListID.SET(pg.Int(params.TargetListID)),
+					todo.Items.Position.SET(nextItemPosition(params.TargetListID)),
+					todo.Items.BlockedByItemID.SET(pg.IntExp(pg.NULL)),
+					todo.Items.UpdatedAt.SET(pg.NOW()),
+					todo.Items.Version.SET(todo.Items.Version.ADD(pg.Int(1))),
+				).
+				WHERE(
+					todo.Items.ItemID.EQ(pg.Int(itemID)).
+						AND(todo.Items.ListID.EQ(pg.Int(listID))),
+				).
+				Sql()
+
+			tag, err := tx.Exec(c.Request().Context(), query, args...)
+
+			if err != nil {
+				requestLogger(c).Error("Error moving item", "err", err)
+				return dbError(err)
+			}
+
+			if tag.RowsAffected() == 0 {
+				return ErrNotFound
+			}
+		}
+
 		query, args := pg.SELECT(
-			todo.Lists.ListID,
-			todo.Lists.Title,
-			todo.Lists.Description,
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
 		).
-			FROM(todo.Lists).
+			FROM(todo.Items).
 			WHERE(
-				todo.Lists.ListID.EQ(pg.Int(listID)).
-					AND(todo.Lists.UserID.EQ(pg.String(userID))),
+				todo.Items.ItemID.EQ(pg.Int(itemID)).
+					AND(todo.Items.ListID.EQ(pg.Int(params.TargetListID))),
 			).
 			Sql()
 
-		rows, _ := db.Query(c.Request().Context(), query, args...)
-		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+		rows, _ := tx.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
 
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return ErrNotFound
 			}
-			c.Logger().Errorf("Error fetching list: %v\n", err)
-			return ErrInternalServerError
-		}
-
-		return c.JSON(http.StatusOK, ListResponse(record))
-	})
-
-	e.POST("/list", func(c echo.Context) error {
-		userID := c.Get("userID").(string)
-		var params ListRequest
-
-		if err := c.Bind(&params); err != nil {
-			return err
+			requestLogger(c).Error("Error fetching item", "err", err)
+			return dbError(err)
 		}
 
-		query, args := todo.Lists.INSERT(
-			todo.Lists.Title,
-			todo.Lists.Description,
-			todo.Lists.UserID,
-		).
-			VALUES(
-				params.Title,
-				params.Description,
-				userID,
-			).
-			RETURNING(
-				todo.Lists.ListID,
-				todo.Lists.Title,
-				todo.Lists.Description,
-			).
-			Sql()
-
-		rows, _ := db.Query(c.Request().Context(), query, args...)
-		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
-
-		if err != nil {
-			c.Logger().Errorf("Error creating list: %v\n", err)
-			return ErrInternalServerError
+		if err := tx.Commit(c.Request().Context()); err != nil {
+			requestLogger(c).Error("Error committing transaction", "err", err)
+			return dbError(err)
 		}
 
-		return c.JSON(http.StatusCreated, ListResponse(record))
+		// The item now belongs to the target list, not the one in the path.
+		return c.JSON(http.StatusOK, ListItemResponse{
+			ListID:       params.TargetListID,
+			ItemResponse: ItemResponse(record),
+		})
 	})
 
-	e.PUT("/list/:list_id", func(c echo.Context) error {
+	api.POST("/list/:list_id/item/:item_id/toggle", func(c echo.Context) error {
 		userID := c.Get("userID").(string)
-		var listID int64
+		var listID, itemID int64
+		var cascade bool
 
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
 			return err
 		}
 
-		var params ListRequest
-
-		if err := c.Bind(&params); err != nil {
+		if err := echo.QueryParamsBinder(c).Bool("cascade", &cascade).BindError(); err != nil {
 			return err
 		}
 
-		query, args := todo.Lists.
+		// Flipping the flag in the UPDATE itself means toggles that aren't
+		// collapsed always cancel out, whatever order they land in.
+		query, args := todo.Items.
 			UPDATE().
 			SET(
-				todo.Lists.Title.SET(pg.String(params.Title)),
-				todo.Lists.Description.SET(pg.String(params.Description)),
+				todo.Items.IsComplete.SET(pg.NOT(todo.Items.IsComplete)),
+				todo.Items.CompletedAt.SET(completedAt(pg.NOT(todo.Items.IsComplete))),
+				todo.Items.UpdatedAt.SET(pg.NOW()),
+				todo.Items.Version.SET(todo.Items.Version.ADD(pg.Int(1))),
 			).
+			FROM(todo.Lists).
 			WHERE(
-				todo.Lists.ListID.EQ(pg.Int(listID)).
-					AND(todo.Lists.UserID.EQ(pg.String(userID))),
+				todo.Items.ListID.EQ(todo.Lists.ListID).
+					AND(todo.Items.ItemID.EQ(pg.Int(itemID))).
+					AND(todo.Items.ListID.EQ(pg.Int(listID))).
+					AND(todo.Lists.UserID.EQ(pg.String(userID))).
+					AND(todo.Lists.DeletedAt.IS_NULL()),
 			).
 			RETURNING(
-				todo.Lists.ListID,
-				todo.Lists.Title,
-				todo.Lists.Description,
+				todo.Items.ItemID,
+				todo.Items.Content,
+				todo.Items.IsComplete,
+				todo.Items.BlockedByItemID,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+				todo.Items.CompletedAt,
+				todo.Items.Version,
+				todo.Items.CreatedAt,
+				todo.Items.UpdatedAt,
 			).
 			Sql()
 
-		rows, _ := db.Query(c.Request().Context(), query, args...)
-		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+		// A duplicate toggle gets the first one's result rather than flipping
+		// the item back. The result is shared, so the first request going
+		// away doesn't cancel it.
+		ctx := context.WithoutCancel(c.Request().Context())
+		key := toggleKey{userID: userID, listID: listID, itemID: itemID, cascade: cascade}
+
+		result, _, err := toggles.Do(key, func() (toggleResult, error) {
+			var result toggleResult
+
+			tx, err := db.Begin(ctx)
+
+			if err != nil {
+				return result, err
+			}
+
+			defer tx.Rollback(ctx)
+
+			rows, _ := tx.Query(ctx, query, args...)
+			result.record, err = pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+
+			if err != nil {
+				return result, err
+			}
+
+			// Only completing an item cascades, toggling it back leaves the
+			// items it blocks as they are.
+			if cascade && result.record.IsComplete {
+				rows, _ := tx.Query(ctx, completeDependents, itemID, listID)
+				result.completed, err = pgx.CollectRows(rows, pgx.RowTo[int64])
+
+				if err != nil {
+					return result, err
+				}
+			}
+
+			return result, tx.Commit(ctx)
+		})
 
 		if err != nil {
 			if errors.Is(err, pgx.ErrNoRows) {
 				return ErrNotFound
 			}
-			c.Logger().Errorf("Error updating list: %v\n", err)
-			return ErrInternalServerError
+			requestLogger(c).Error("Error toggling item", "err", err)
+			return dbError(err)
 		}
 
-		return c.JSON(http.StatusOK, ListResponse(record))
+		if !cascade {
+			return c.JSON(http.StatusOK, ItemResponse(result.record))
+		}
+
+		affected := append([]int64{itemID}, result.completed...)
+		slices.Sort(affected)
+
+		return c.JSON(http.StatusOK, ToggleCascadeResponse{
+			Item:            ItemResponse(result.record),
+			AffectedItemIDs: affected,
+		})
 	})
 
-	e.PATCH("/list/:list_id", func(c echo.Context) error {
+	api.DELETE("/list/:list_id/item/:item_id", func(c echo.Context) error {
 		userID := c.Get("userID").(string)
-		var listID int64
+		var listID, itemID int64
 
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
 			return err
 		}
 
-		var params ListPartialRequest
+		version, hasVersion, err := ifMatchVersion(c)
 
-		if err := c.Bind(&params); err != nil {
+		if err != nil {
 			return err
 		}
 
-		stmt := todo.Lists.
-			UPDATE().
-			SET(todo.Lists.ListID.SET(pg.Int(listID))).
-			WHERE(
-				todo.Lists.ListID.EQ(pg.Int(listID)).
-					AND(todo.Lists.UserID.EQ(pg.String(userID))),
-			).
-			RETURNING(
-				todo.Lists.ListID,
-				todo.Lists.Title,
-				todo.Lists.Description,
-			)
+		condition := todo.Items.ListID.EQ(todo.Lists.ListID).
+			AND(todo.Items.ItemID.EQ(pg.Int(itemID))).
+			AND(todo.Items.ListID.EQ(pg.Int(listID))).
+			AND(todo.Lists.UserID.EQ(pg.String(userID))).
+			AND(todo.Lists.DeletedAt.IS_NULL())
 
-		if params.Title != nil {
-			stmt = stmt.SET(todo.Lists.Title.SET(pg.String(*params.Title)))
+		if hasVersion {
+			condition = condition.AND(todo.Items.Version.EQ(pg.Int(version)))
 		}
 
-		if params.Description != nil {
-			stmt = stmt.SET(todo.Lists.Description.SET(pg.String(*params.Description)))
+		query, args := todo.Items.
+			DELETE().
+			USING(todo.Lists).
+			WHERE(condition).
+			Sql()
+
+		tag, err := db.Exec(c.Request().Context(), query, args...)
+
+		if err != nil {
+			requestLogger(c).Error("Error deleting item", "err", err)
+			return dbError(err)
 		}
 
-		query, args := stmt.Sql()
+		if tag.RowsAffected() == 0 {
+			if hasVersion {
+				exists, err := itemExists(c.Request().Context(), db, userID, listID, itemID)
 
-		rows, _ := db.Query(c.Request().Context(), query, args...)
-		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ListsRecord])
+				if err != nil {
+					requestLogger(c).Error("Error checking if item exists", "err", err)
+					return dbError(err)
+				}
 
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				return ErrNotFound
+				if exists {
+					return ErrVersionMismatch
+				}
 			}
-			c.Logger().Errorf("Error updating list: %v\n", err)
-			return ErrInternalServerError
+
+			return ErrNotFound
 		}
 
-		return c.JSON(http.StatusOK, ListResponse(record))
+		return deleted(c)
 	})
 
-	e.DELETE("/list/:list_id", func(c echo.Context) error {
+	api.GET("/print", func(c echo.Context) error {
 		userID := c.Get("userID").(string)
-		var listID int64
-
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
-			return err
-		}
 
-		query, args := todo.Lists.
-			DELETE().
+		query, args := pg.SELECT(
+			todo.Lists.ListID,
+			todo.Lists.Title,
+			todo.Lists.Description,
+			todo.Lists.IsPinned,
+			todo.Lists.Category,
+			todo.Lists.Version,
+			todo.Lists.CreatedAt,
+			todo.Lists.UpdatedAt,
+			todo.Items.ItemID,
+			todo.Items.Content,
+			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
+		).
+			FROM(
+				todo.Lists.LEFT_JOIN(
+					todo.Items,
+					todo.Items.ListID.EQ(todo.Lists.ListID).
+						AND(todo.Items.IsComplete.IS_FALSE()),
+				),
+			).
 			WHERE(
-				todo.Lists.ListID.EQ(pg.Int(listID)).
-					AND(todo.Lists.UserID.EQ(pg.String(userID))),
+				todo.Lists.UserID.EQ(pg.String(userID)).
+					AND(todo.Lists.DeletedAt.IS_NULL()),
 			).
+			ORDER_BY(todo.Lists.IsPinned.DESC(), todo.Lists.ListID, todo.Items.Position, todo.Items.ItemID).
 			Sql()
 
-		_, err := db.Exec(c.Request().Context(), query, args...)
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListJoinItemsRecord])
 
 		if err != nil {
-			c.Logger().Errorf("Error deleting list: %v\n", err)
-			return ErrInternalServerError
+			requestLogger(c).Error("Error fetching lists", "err", err)
+			return dbError(err)
 		}
 
-		return c.NoContent(http.StatusNoContent)
-	})
+		lists := []ListWithItemsResponse{}
 
-	e.GET("/list/:list_id/item", func(c echo.Context) error {
-		userID := c.Get("userID").(string)
-		var listID int64
+		// Rows are ordered by list, so a new list starts whenever the list id
+		// changes.
+		for _, record := range records {
+			if len(lists) == 0 || lists[len(lists)-1].ListID != record.ListID {
+				lists = append(lists, ListWithItemsResponse{
+					ListResponse: ListResponse(record.ListsRecord),
+					Items:        []ItemResponse{},
+				})
+			}
 
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
-			return err
+			if item, ok := record.Item(); ok {
+				list := &lists[len(lists)-1]
+				list.Items = append(list.Items, item)
+			}
 		}
 
-		{
-			query, args := pg.SELECT(pg.Int64(1)).
-				FROM(todo.Lists).
-				WHERE(
-					todo.Lists.ListID.EQ(pg.Int(listID)).
-						AND(todo.Lists.UserID.EQ(pg.String(userID))),
-				).
-				Sql()
-
-			rows, _ := db.Query(c.Request().Context(), query, args...)
-			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+		return c.JSON(http.StatusOK, PrintResponse{Lists: lists})
+	})
 
-			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
-					return ErrNotFound
-				}
-				c.Logger().Errorf("Error checking if list exists: %v\n", err)
-				return ErrInternalServerError
-			}
-		}
+	api.GET("/items/overdue", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
 
 		query, args := pg.SELECT(
+			todo.Items.ListID,
 			todo.Items.ItemID,
 			todo.Items.Content,
 			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
 		).
-			FROM(todo.Items).
-			WHERE(todo.Items.ListID.EQ(pg.Int(listID))).
-			ORDER_BY(todo.Items.ItemID).
+			FROM(todo.Items.INNER_JOIN(todo.Lists, todo.Lists.ListID.EQ(todo.Items.ListID))).
+			WHERE(
+				todo.Lists.UserID.EQ(pg.String(userID)).
+					AND(todo.Lists.DeletedAt.IS_NULL()).
+					AND(todo.Items.IsComplete.IS_FALSE()).
+					AND(todo.Items.DueDate.LT(pg.NOW())),
+			).
+			ORDER_BY(todo.Items.DueDate, todo.Items.ItemID).
 			Sql()
 
 		rows, _ := db.Query(c.Request().Context(), query, args...)
-		records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ItemsRecord])
+		records, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListItemsRecord])
 
 		if err != nil {
-			c.Logger().Errorf("Error fetching items: %v\n", err)
-			return ErrInternalServerError
+			requestLogger(c).Error("Error fetching overdue items", "err", err)
+			return dbError(err)
 		}
 
-		var items = make([]ItemResponse, 0, len(records))
+		items := make([]ListItemResponse, 0, len(records))
 
 		for _, record := range records {
-			items = append(items, ItemResponse(record))
+			items = append(items, ListItemResponse{
+				ListID:       record.ListID,
+				ItemResponse: ItemResponse(record.ItemsRecord),
+			})
 		}
 
 		return c.JSON(http.StatusOK, items)
 	})
 
-	e.GET("/list/:list_id/item/:item_id", func(c echo.Context) error {
+	api.GET("/stats", func(c echo.Context) error {
 		userID := c.Get("userID").(string)
-		var listID, itemID int64
 
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
+		// One pass over the user's lists and their items, counted in the
+		// database.
+		query, args := pg.SELECT(
+			pg.COUNT(pg.DISTINCT(todo.Lists.ListID)).AS("stats.lists"),
+			pg.COUNT(todo.Items.ItemID).AS("stats.items"),
+			pg.COUNT(pg.CASE().WHEN(todo.Items.IsComplete.IS_TRUE()).THEN(pg.Int(1))).AS("stats.completed_items"),
+			pg.COUNT(
+				pg.CASE().
+					WHEN(todo.Items.CompletedAt.GT_EQ(pg.NOW().SUB(pg.INTERVAL(7, pg.DAY)))).
+					THEN(pg.Int(1)),
+			).AS("stats.completed_last_7_days"),
+		).
+			FROM(todo.Lists.LEFT_JOIN(todo.Items, todo.Items.ListID.EQ(todo.Lists.ListID))).
+			WHERE(
+				todo.Lists.UserID.EQ(pg.String(userID)).
+					AND(todo.Lists.DeletedAt.IS_NULL()),
+			).
+			Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[StatsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error calculating stats", "err", err)
+			return dbError(err)
+		}
+
+		stats := StatsResponse{
+			Lists:              record.Lists,
+			Items:              record.Items,
+			CompletedItems:     record.CompletedItems,
+			CompletedLast7Days: record.CompletedLast7Days,
+		}
+
+		if record.Items > 0 {
+			stats.CompletionPercent = math.Round(float64(record.CompletedItems)/float64(record.Items)*1000) / 10
+		}
+
+		return c.JSON(http.StatusOK, stats)
+	})
+
+	api.GET("/search", func(c echo.Context) error {
+		userID := c.Get("userID").(string)
+		term := strings.TrimSpace(c.QueryParam("q"))
+
+		if term == "" {
+			return ErrMissingSearchQuery
+		}
+
+		listsAfter, itemsAfter, limit := int64(0), int64(0), int64(defaultListLimit)
+
+		if err := echo.QueryParamsBinder(c).Int64("lists_after", &listsAfter).Int64("items_after", &itemsAfter).Int64("limit", &limit).BindError(); err != nil {
 			return err
 		}
 
+		// Like items in a list, pages are keyed on ids so they stay stable
+		// while lists and items are added and removed.
+		paginated := c.QueryParam("lists_after") != "" || c.QueryParam("items_after") != "" || c.QueryParam("limit") != ""
+
+		if paginated && (limit < 1 || limit > maxListLimit) {
+			return ErrInvalidLimit
+		}
+
+		pattern := pg.LOWER(pg.String(containsPattern(term)))
+
+		listCondition := todo.Lists.UserID.EQ(pg.String(userID)).
+			AND(todo.Lists.DeletedAt.IS_NULL()).
+			AND(
+				pg.LOWER(todo.Lists.Title).LIKE(pattern).
+					OR(pg.LOWER(todo.Lists.Description).LIKE(pattern)),
+			)
+
+		itemsFrom := todo.Items.INNER_JOIN(todo.Lists, todo.Lists.ListID.EQ(todo.Items.ListID))
+		itemCondition := todo.Lists.UserID.EQ(pg.String(userID)).
+			AND(todo.Lists.DeletedAt.IS_NULL()).
+			AND(pg.LOWER(todo.Items.Content).LIKE(pattern))
+
+		// The total uses the same conditions as the searches, before the
+		// cursors narrow them to a page.
 		{
-			query, args := pg.SELECT(pg.Int64(1)).
-				FROM(todo.Lists).
-				WHERE(
-					todo.Lists.ListID.EQ(pg.Int(listID)).
-						AND(todo.Lists.UserID.EQ(pg.String(userID))),
-				).
-				Sql()
+			query, args := pg.SELECT(
+				pg.IntExp(pg.SELECT(pg.COUNT(pg.STAR)).FROM(todo.Lists).WHERE(listCondition)).
+					ADD(pg.IntExp(pg.SELECT(pg.COUNT(pg.STAR)).FROM(itemsFrom).WHERE(itemCondition))),
+			).Sql()
 
 			rows, _ := db.Query(c.Request().Context(), query, args...)
-			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+			total, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
 
 			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
-					return ErrNotFound
-				}
-				c.Logger().Errorf("Error checking if list exists: %v\n", err)
-				return ErrInternalServerError
+				requestLogger(c).Error("Error counting search results", "err", err)
+				return dbError(err)
 			}
+
+			c.Response().Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
 		}
 
-		query, args := pg.SELECT(
+		itemOrder := []pg.OrderByClause{todo.Items.ListID.ASC(), todo.Items.Position.ASC(), todo.Items.ItemID.ASC()}
+
+		if paginated {
+			listCondition = listCondition.AND(todo.Lists.ListID.GT(pg.Int(listsAfter)))
+			itemCondition = itemCondition.AND(todo.Items.ItemID.GT(pg.Int(itemsAfter)))
+			itemOrder = []pg.OrderByClause{todo.Items.ItemID.ASC()}
+		}
+
+		listStmt := pg.SELECT(
+			todo.Lists.ListID,
+			todo.Lists.Title,
+			todo.Lists.Description,
+			todo.Lists.IsPinned,
+			todo.Lists.Category,
+			todo.Lists.Version,
+			todo.Lists.CreatedAt,
+			todo.Lists.UpdatedAt,
+		).
+			FROM(todo.Lists).
+			WHERE(listCondition).
+			ORDER_BY(todo.Lists.ListID)
+
+		itemStmt := pg.SELECT(
+			todo.Items.ListID,
 			todo.Items.ItemID,
 			todo.Items.Content,
 			todo.Items.IsComplete,
+			todo.Items.BlockedByItemID,
+			todo.Items.Position,
+			todo.Items.DueDate,
+			todo.Items.Priority,
+			todo.Items.CompletedAt,
+			todo.Items.Version,
+			todo.Items.CreatedAt,
+			todo.Items.UpdatedAt,
 		).
-			FROM(todo.Items).
-			WHERE(
-				todo.Items.ItemID.EQ(pg.Int(itemID)).
-					AND(todo.Items.ListID.EQ(pg.Int(listID))),
-			).
-			Sql()
+			FROM(itemsFrom).
+			WHERE(itemCondition).
+			ORDER_BY(itemOrder...)
+
+		// One row past the page tells whether there is another page.
+		if paginated {
+			listStmt = listStmt.LIMIT(limit + 1)
+			itemStmt = itemStmt.LIMIT(limit + 1)
+		}
+
+		query, args := listStmt.Sql()
+
+		rows, _ := db.Query(c.Request().Context(), query, args...)
+		listRecords, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error searching lists", "err", err)
+			return dbError(err)
+		}
+
+		query, args = itemStmt.Sql()
+
+		rows, _ = db.Query(c.Request().Context(), query, args...)
+		itemRecords, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListItemsRecord])
+
+		if err != nil {
+			requestLogger(c).Error("Error searching items", "err", err)
+			return dbError(err)
+		}
+
+		var nextListsCursor, nextItemsCursor *int64
+
+		if paginated && int64(len(listRecords)) > limit {
+			listRecords = listRecords[:limit]
+			nextListsCursor = &listRecords[limit-1].ListID
+		}
+
+		if paginated && int64(len(itemRecords)) > limit {
+			itemRecords = itemRecords[:limit]
+			nextItemsCursor = &itemRecords[limit-1].ItemID
+		}
+
+		results := SearchResponse{
+			Lists: make([]ListResponse, 0, len(listRecords)),
+			Items: make([]ListItemResponse, 0, len(itemRecords)),
+		}
 
-		rows, _ := db.Query(c.Request().Context(), query, args...)
-		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+		for _, record := range listRecords {
+			results.Lists = append(results.Lists, ListResponse(record))
+		}
 
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				return ErrNotFound
-			}
-			c.Logger().Errorf("Error fetching item: %v\n", err)
-			return ErrInternalServerError
+		for _, record := range itemRecords {
+			results.Items = append(results.Items, ListItemResponse{
+				ListID:       record.ListID,
+				ItemResponse: ItemResponse(record.ItemsRecord),
+			})
 		}
 
-		return c.JSON(http.StatusOK, ItemResponse(record))
+		if paginated {
+			return c.JSON(http.StatusOK, SearchPageResponse{
+				SearchResponse:  results,
+				NextListsCursor: nextListsCursor,
+				NextItemsCursor: nextItemsCursor,
+			})
+		}
+
+		return c.JSON(http.StatusOK, results)
 	})
 
-	e.POST("/list/:list_id/item", func(c echo.Context) error {
-		userID := c.Get("userID").(string)
-		var listID int64
+	if flags.Enabled("export") {
+		api.GET("/export", func(c echo.Context) error {
+			userID := c.Get("userID").(string)
+			var listIDs []int64
 
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
-			return err
-		}
+			if err := echo.QueryParamsBinder(c).MustBindWithDelimiter("list_ids", &listIDs, ",").BindError(); err != nil {
+				return err
+			}
 
-		var params ItemRequest
+			ids := make([]pg.Expression, 0, len(listIDs))
 
-		if err := c.Bind(&params); err != nil {
-			return err
-		}
+			for _, listID := range listIDs {
+				ids = append(ids, pg.Int(listID))
+			}
 
-		{
-			query, args := pg.SELECT(pg.Int64(1)).
+			query, args := pg.SELECT(
+				todo.Lists.ListID,
+				todo.Lists.Title,
+				todo.Lists.Description,
+				todo.Lists.IsPinned,
+				todo.Lists.Category,
+				todo.Lists.Version,
+				todo.Lists.CreatedAt,
+				todo.Lists.UpdatedAt,
+			).
 				FROM(todo.Lists).
 				WHERE(
-					todo.Lists.ListID.EQ(pg.Int(listID)).
-						AND(todo.Lists.UserID.EQ(pg.String(userID))),
+					todo.Lists.ListID.IN(ids...).
+						AND(todo.Lists.UserID.EQ(pg.String(userID))).
+						AND(todo.Lists.DeletedAt.IS_NULL()),
 				).
+				ORDER_BY(todo.Lists.ListID).
 				Sql()
 
 			rows, _ := db.Query(c.Request().Context(), query, args...)
-			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+			listRecords, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListsRecord])
 
 			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
-					return ErrNotFound
+				requestLogger(c).Error("Error fetching lists", "err", err)
+				return dbError(err)
+			}
+
+			lists := make([]ListWithItemsResponse, 0, len(listRecords))
+			index := make(map[int64]int, len(listRecords))
+
+			for i, record := range listRecords {
+				lists = append(lists, ListWithItemsResponse{ListResponse: ListResponse(record), Items: []ItemResponse{}})
+				index[record.ListID] = i
+			}
+
+			var missing []int64
+
+			for _, listID := range listIDs {
+				if _, ok := index[listID]; !ok {
+					missing = append(missing, listID)
 				}
-				c.Logger().Errorf("Error checking if list exists: %v\n", err)
-				return ErrInternalServerError
 			}
-		}
 
-		query, args := todo.Items.
-			INSERT(
-				todo.Items.Content,
-				todo.Items.IsComplete,
+			if len(missing) > 0 {
+				return problem.WithExtensions(
+					http.StatusNotFound,
+					"Some of the requested lists were not found",
+					map[string]any{"list_ids": missing},
+				)
+			}
+
+			query, args = pg.SELECT(
 				todo.Items.ListID,
-			).
-			VALUES(
-				params.Content,
-				params.IsComplete,
-				listID,
-			).
-			RETURNING(
 				todo.Items.ItemID,
 				todo.Items.Content,
 				todo.Items.IsComplete,
+				todo.Items.BlockedByItemID,
+				todo.Items.Position,
+				todo.Items.DueDate,
+				todo.Items.Priority,
+				todo.Items.CompletedAt,
+				todo.Items.Version,
+				todo.Items.CreatedAt,
+				todo.Items.UpdatedAt,
 			).
-			Sql()
+				FROM(todo.Items).
+				WHERE(todo.Items.ListID.IN(ids...)).
+				ORDER_BY(todo.Items.ListID, todo.Items.Position, todo.Items.ItemID).
+				Sql()
 
-		rows, _ := db.Query(c.Request().Context(), query, args...)
-		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+			rows, _ = db.Query(c.Request().Context(), query, args...)
+			itemRecords, err := pgx.CollectRows(rows, pgx.RowToStructByName[ListItemsRecord])
 
-		if err != nil {
-			c.Logger().Errorf("Error creating item: %v\n", err)
-			return ErrInternalServerError
-		}
+			if err != nil {
+				requestLogger(c).Error("Error fetching items", "err", err)
+				return dbError(err)
+			}
 
-		return c.JSON(http.StatusCreated, ItemResponse(record))
-	})
+			for _, record := range itemRecords {
+				list := &lists[index[record.ListID]]
+				list.Items = append(list.Items, ItemResponse(record.ItemsRecord))
+			}
 
-	e.PUT("/list/:list_id/item/:item_id", func(c echo.Context) error {
-		userID := c.Get("userID").(string)
-		var listID, itemID int64
+			return c.JSON(http.StatusOK, ExportResponse{Lists: lists})
+		})
 
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
-			return err
-		}
+		api.GET("/list/:list_id/export", func(c echo.Context) error {
+			userID := c.Get("userID").(string)
+			var listID int64
 
-		var params ItemRequest
+			if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).BindError(); err != nil {
+				return err
+			}
 
-		if err := c.Bind(&params); err != nil {
-			return err
-		}
+			if format := c.QueryParam("format"); format != "" && format != "csv" {
+				return ErrInvalidFormat
+			}
 
-		{
-			query, args := pg.SELECT(pg.Int64(1)).
-				FROM(todo.Lists).
-				WHERE(
-					todo.Lists.ListID.EQ(pg.Int(listID)).
-						AND(todo.Lists.UserID.EQ(pg.String(userID))),
-				).
+			{
+				query, args := pg.SELECT(pg.Int64(1)).
+					FROM(todo.Lists).
+					WHERE(
+						todo.Lists.ListID.EQ(pg.Int(listID)).
+							AND(todo.Lists.UserID.EQ(pg.String(userID))).
+							AND(todo.Lists.DeletedAt.IS_NULL()),
+					).
+					Sql()
+
+				rows, _ := db.Query(c.Request().Context(), query, args...)
+				_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+				if err != nil {
+					if errors.Is(err, pgx.ErrNoRows) {
+						return ErrNotFound
+					}
+					requestLogger(c).Error("Error checking if list exists", "err", err)
+					return dbError(err)
+				}
+			}
+
+			query, args := pg.SELECT(
+				todo.Items.ItemID,
+				todo.Items.Content,
+				todo.Items.IsComplete,
+			).
+				FROM(todo.Items).
+				WHERE(todo.Items.ListID.EQ(pg.Int(listID))).
+				ORDER_BY(todo.Items.Position, todo.Items.ItemID).
 				Sql()
 
-			rows, _ := db.Query(c.Request().Context(), query, args...)
-			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+			rows, err := db.Query(c.Request().Context(), query, args...)
 
 			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
-					return ErrNotFound
+				requestLogger(c).Error("Error exporting items", "err", err)
+				return dbError(err)
+			}
+
+			defer rows.Close()
+
+			// The first row is read before anything is written, so a query
+			// that fails outright still gets a problem response.
+			next := rows.Next()
+
+			if err := rows.Err(); err != nil {
+				requestLogger(c).Error("Error exporting items", "err", err)
+				return dbError(err)
+			}
+
+			c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+			c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=list-%d.csv", listID))
+			c.Response().WriteHeader(http.StatusOK)
+
+			w := csv.NewWriter(c.Response())
+
+			if err := w.Write([]string{"item_id", "content", "is_complete"}); err != nil {
+				return err
+			}
+
+			// Rows are written as they are read so large lists aren't held in
+			// memory. Once the header row is out an error can only be logged.
+			var itemID int64
+			var content string
+			var isComplete bool
+
+			for ; next; next = rows.Next() {
+				if err := rows.Scan(&itemID, &content, &isComplete); err != nil {
+					requestLogger(c).Error("Error exporting items", "err", err)
+					return err
+				}
+
+				if err := w.Write([]string{
+					strconv.FormatInt(itemID, 10),
+					content,
+					strconv.FormatBool(isComplete),
+				}); err != nil {
+					return err
 				}
-				c.Logger().Errorf("Error checking if list exists: %v\n", err)
-				return ErrInternalServerError
 			}
-		}
 
-		query, args := todo.Items.
-			UPDATE().
-			SET(
-				todo.Items.Content.SET(pg.String(params.Content)),
-				todo.Items.IsComplete.SET(pg.Bool(params.IsComplete)),
-			).
-			WHERE(
-				todo.Items.ItemID.EQ(pg.Int(itemID)).
-					AND(todo.Items.ListID.EQ(pg.Int(listID))),
-			).
-			RETURNING(
-				todo.Items.ItemID,
-				todo.Items.Content,
-				todo.Items.IsComplete,
-			).Sql()
+			if err := rows.Err(); err != nil {
+				requestLogger(c).Error("Error exporting items", "err", err)
+				return err
+			}
 
-		rows, _ := db.Query(c.Request().Context(), query, args...)
-		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+			w.Flush()
 
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				return ErrNotFound
+			return w.Error()
+		})
+	}
+
+	if flags.Enabled("import") {
+		api.POST("/import", func(c echo.Context) error {
+			userID := c.Get("userID").(string)
+			mode := c.QueryParam("mode")
+
+			if mode == "" {
+				mode = "merge"
 			}
-			c.Logger().Errorf("Error updating item: %v\n", err)
-			return ErrInternalServerError
-		}
 
-		return c.JSON(http.StatusOK, ItemResponse(record))
-	})
+			if mode != "merge" && mode != "replace" {
+				return ErrInvalidImportMode
+			}
 
-	e.PATCH("/list/:list_id/item/:item_id", func(c echo.Context) error {
-		userID := c.Get("userID").(string)
-		var listID, itemID int64
+			var params ExportResponse
 
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
-			return err
-		}
+			if err := c.Bind(&params); err != nil {
+				return err
+			}
 
-		var params ItemPartialRequest
+			for i := range params.Lists {
+				list := &params.Lists[i]
 
-		if err := c.Bind(&params); err != nil {
-			return err
-		}
+				if err := checkTitle(list.Title); err != nil {
+					return err
+				}
 
-		{
-			query, args := pg.SELECT(pg.Int64(1)).
-				FROM(todo.Lists).
-				WHERE(
-					todo.Lists.ListID.EQ(pg.Int(listID)).
-						AND(todo.Lists.UserID.EQ(pg.String(userID))),
-				).
-				Sql()
+				if list.Category == "" {
+					list.Category = "other"
+				}
 
-			rows, _ := db.Query(c.Request().Context(), query, args...)
-			_, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+				if !slices.Contains(listCategories, list.Category) {
+					return ErrInvalidCategory
+				}
+
+				for j := range list.Items {
+					list.Items[j].Content = strings.TrimSpace(list.Items[j].Content)
+
+					if err := checkContent(list.Items[j].Content); err != nil {
+						return err
+					}
+
+					if list.Items[j].Priority == "" {
+						list.Items[j].Priority = "medium"
+					}
+
+					if !slices.Contains(itemPriorities, list.Items[j].Priority) {
+						return ErrInvalidPriority
+					}
+				}
+			}
+
+			tx, err := db.Begin(c.Request().Context())
 
 			if err != nil {
-				if errors.Is(err, pgx.ErrNoRows) {
-					return ErrNotFound
+				requestLogger(c).Error("Error starting transaction", "err", err)
+				return dbError(err)
+			}
+
+			defer tx.Rollback(c.Request().Context())
+
+			var usageBefore int64
+
+			if cfg.MaxStorageBytes > 0 {
+				usageBefore, err = storageUsage(c.Request().Context(), tx, userID)
+
+				if err != nil {
+					requestLogger(c).Error("Error calculating storage usage", "err", err)
+					return dbError(err)
 				}
-				c.Logger().Errorf("Error checking if list exists: %v\n", err)
-				return ErrInternalServerError
 			}
-		}
 
-		stmt := todo.Items.
-			UPDATE().
-			SET(todo.Items.ItemID.SET(pg.Int(itemID))).
-			WHERE(
-				todo.Items.ItemID.EQ(pg.Int(itemID)).
-					AND(todo.Items.ListID.EQ(pg.Int(listID))),
-			).
-			RETURNING(
-				todo.Items.ItemID,
-				todo.Items.Content,
-				todo.Items.IsComplete,
-			)
+			var deletedListIDs []int64
+
+			if mode == "replace" {
+				{
+					query, args := todo.Items.
+						DELETE().
+						USING(todo.Lists).
+						WHERE(
+							todo.Items.ListID.EQ(todo.Lists.ListID).
+								AND(todo.Lists.UserID.EQ(pg.String(userID))),
+						).
+						Sql()
+
+					if _, err := tx.Exec(c.Request().Context(), query, args...); err != nil {
+						requestLogger(c).Error("Error deleting items", "err", err)
+						return dbError(err)
+					}
+				}
 
-		if params.Content != nil {
-			stmt = stmt.SET(todo.Items.Content.SET(pg.String(*params.Content)))
-		}
+				query, args := todo.Lists.
+					DELETE().
+					WHERE(todo.Lists.UserID.EQ(pg.String(userID))).
+					RETURNING(todo.Lists.ListID).
+					Sql()
 
-		if params.IsComplete != nil {
-			stmt = stmt.SET(todo.Items.IsComplete.SET(pg.Bool(*params.IsComplete)))
-		}
+				rows, _ := tx.Query(c.Request().Context(), query, args...)
+				deletedListIDs, err = pgx.CollectRows(rows, pgx.RowTo[int64])
 
-		query, args := stmt.Sql()
+				if err != nil {
+					requestLogger(c).Error("Error deleting lists", "err", err)
+					return dbError(err)
+				}
+			}
 
-		rows, _ := db.Query(c.Request().Context(), query, args...)
-		record, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[ItemsRecord])
+			itemCount := 0
 
-		if err != nil {
-			if errors.Is(err, pgx.ErrNoRows) {
-				return ErrNotFound
+			for _, list := range params.Lists {
+				query, args := todo.Lists.INSERT(
+					todo.Lists.Title,
+					todo.Lists.Description,
+					todo.Lists.IsPinned,
+					todo.Lists.Category,
+					todo.Lists.UserID,
+				).
+					VALUES(
+						list.Title,
+						list.Description,
+						list.IsPinned,
+						list.Category,
+						userID,
+					).
+					RETURNING(todo.Lists.ListID).
+					Sql()
+
+				rows, _ := tx.Query(c.Request().Context(), query, args...)
+				listID, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+				if err != nil {
+					requestLogger(c).Error("Error creating list", "err", err)
+					return dbError(err)
+				}
+
+				if len(list.Items) == 0 {
+					continue
+				}
+
+				stmt := todo.Items.INSERT(
+					todo.Items.Content,
+					todo.Items.IsComplete,
+					todo.Items.CompletedAt,
+					todo.Items.ListID,
+					todo.Items.Position,
+					todo.Items.DueDate,
+					todo.Items.Priority,
+				)
+
+				for n, item := range list.Items {
+					stmt = stmt.VALUES(item.Content, item.IsComplete, newCompletedAt(item.IsComplete), listID, n+1, item.DueDate, item.Priority)
+				}
+
+				query, args = stmt.RETURNING(todo.Items.ItemID).Sql()
+
+				rows, _ = tx.Query(c.Request().Context(), query, args...)
+				itemIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+
+				if err != nil {
+					requestLogger(c).Error("Error creating items", "err", err)
+					return dbError(err)
+				}
+
+				// New ids come back in insertion order, so exported item ids can be
+				// mapped to new ones to carry blocked_by_item_id over.
+				newItemIDs := make(map[int64]int64, len(itemIDs))
+
+				for n, item := range list.Items {
+					newItemIDs[item.ItemID] = itemIDs[n]
+				}
+
+				for n, item := range list.Items {
+					if item.BlockedByItemID == nil {
+						continue
+					}
+
+					blockerID, ok := newItemIDs[*item.BlockedByItemID]
+
+					if !ok {
+						continue
+					}
+
+					if blockerID == itemIDs[n] {
+						return ErrSelfBlocked
+					}
+
+					// Links are added one at a time, so each is checked
+					// against the ones before it like any other update.
+					chain, err := blockerChain(c.Request().Context(), tx, listID, blockerID)
+
+					if err != nil {
+						requestLogger(c).Error("Error checking blocking item", "err", err)
+						return dbError(err)
+					}
+
+					if slices.Contains(chain, itemIDs[n]) {
+						return ErrBlockedCycle
+					}
+
+					if cfg.MaxDependencyDepth > 0 {
+						rows, _ := tx.Query(c.Request().Context(), dependentDepth, itemIDs[n])
+						depth, err := pgx.CollectOneRow(rows, pgx.RowTo[int64])
+
+						if err != nil {
+							requestLogger(c).Error("Error checking dependent items", "err", err)
+							return dbError(err)
+						}
+
+						if int64(len(chain))+depth > cfg.MaxDependencyDepth {
+							return ErrDependencyTooDeep
+						}
+					}
+
+					query, args := todo.Items.
+						UPDATE().
+						SET(
+							todo.Items.BlockedByItemID.SET(pg.Int(blockerID)),
+							todo.Items.UpdatedAt.SET(pg.NOW()),
+							todo.Items.Version.SET(todo.Items.Version.ADD(pg.Int(1))),
+						).
+						WHERE(todo.Items.ItemID.EQ(pg.Int(itemIDs[n]))).
+						Sql()
+
+					if _, err := tx.Exec(c.Request().Context(), query, args...); err != nil {
+						requestLogger(c).Error("Error updating item", "err", err)
+						return dbError(err)
+					}
+				}
+
+				itemCount += len(itemIDs)
 			}
-			c.Logger().Errorf("Error fetching item: %v\n", err)
-			return ErrInternalServerError
-		}
 
-		return c.JSON(http.StatusOK, ItemResponse(record))
-	})
+			if cfg.MaxStorageBytes > 0 {
+				usage, err := storageUsage(c.Request().Context(), tx, userID)
 
-	e.DELETE("/list/:list_id/item/:item_id", func(c echo.Context) error {
-		userID := c.Get("userID").(string)
-		var listID, itemID int64
+				if err != nil {
+					requestLogger(c).Error("Error calculating storage usage", "err", err)
+					return dbError(err)
+				}
 
-		if err := echo.PathParamsBinder(c).MustInt64("list_id", &listID).MustInt64("item_id", &itemID).BindError(); err != nil {
-			return err
-		}
+				if usage > usageBefore && usage > cfg.MaxStorageBytes {
+					return ErrStorageQuotaExceeded
+				}
+			}
 
-		query, args := todo.Items.
-			DELETE().
-			USING(todo.Lists).
-			WHERE(
-				todo.Items.ListID.EQ(todo.Lists.ListID).
-					AND(todo.Items.ItemID.EQ(pg.Int(itemID)).
-						AND(todo.Items.ListID.EQ(pg.Int(listID)).
-							AND(todo.Lists.UserID.EQ(pg.String(userID))))),
-			).
-			Sql()
+			if err := tx.Commit(c.Request().Context()); err != nil {
+				requestLogger(c).Error("Error committing transaction", "err", err)
+				return dbError(err)
+			}
 
-		_, err := db.Exec(c.Request().Context(), query, args...)
+			for _, listID := range deletedListIDs {
+				listCache.Remove(listCacheKey{userID: userID, listID: listID})
+			}
 
-		if err != nil {
-			c.Logger().Errorf("Error deleting item: %v\n", err)
-			return ErrInternalServerError
-		}
+			return c.JSON(http.StatusCreated, ImportResponse{
+				Lists: len(params.Lists),
+				Items: itemCount,
+			})
+		})
+	}
 
-		return c.NoContent(http.StatusNoContent)
-	})
+	if flags.Enabled("usage") {
+		api.GET("/usage", func(c echo.Context) error {
+			userID := c.Get("userID").(string)
 
-	go func() {
-		if err := e.Start(":8000"); err != nil && err != http.ErrServerClosed {
-			e.Logger.Fatal(err)
-		}
-	}()
+			usage, err := storageUsage(c.Request().Context(), db, userID)
 
-	<-ctx.Done()
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := e.Shutdown(ctx); err != nil {
-		e.Logger.Fatal(err)
+			if err != nil {
+				requestLogger(c).Error("Error calculating storage usage", "err", err)
+				return dbError(err)
+			}
+
+			return c.JSON(http.StatusOK, UsageResponse{
+				StorageBytes:    usage,
+				MaxStorageBytes: cfg.MaxStorageBytes,
+			})
+		})
+	}
+
+	if e.Debug {
+		api.GET("/debug/features", func(c echo.Context) error {
+			return c.JSON(http.StatusOK, flags)
+		})
+
+		api.GET("/whoami", func(c echo.Context) error {
+			claims := jwtmiddleware.Claims(c)
+
+			return c.JSON(http.StatusOK, WhoAmIResponse{
+				Subject:  claims.RegisteredClaims.Subject,
+				Audience: claims.RegisteredClaims.Audience,
+				Issuer:   claims.RegisteredClaims.Issuer,
+				Expiry:   time.Unix(claims.RegisteredClaims.Expiry, 0).UTC(),
+				Claims:   *claims.CustomClaims.(*jwtmiddleware.CustomClaims),
+			})
+		})
 	}
 }
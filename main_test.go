@@ -0,0 +1,1085 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradydean/go-todo-api/internal/pkg/config"
+	"github.com/bradydean/go-todo-api/internal/pkg/features"
+	"github.com/bradydean/go-todo-api/internal/pkg/idempotency"
+	"github.com/bradydean/go-todo-api/internal/pkg/problem"
+	"github.com/bradydean/go-todo-api/internal/pkg/ratelimit"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/labstack/echo/v4"
+)
+
+// testUserHeader carries the user id in tests in place of a bearer token.
+const testUserHeader = "X-Test-User"
+
+func testAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Set("userID", c.Request().Header.Get(testUserHeader))
+		return next(c)
+	}
+}
+
+// newTestServer returns a server with every feature enabled and the default
+// configuration. db may be nil for tests that never reach a handler.
+func newTestServer(t *testing.T, db *pgxpool.Pool) *echo.Echo {
+	t.Helper()
+	return newTestServerWithLogger(t, db, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func newTestServerWithLogger(t *testing.T, db *pgxpool.Pool, logger *slog.Logger) *echo.Echo {
+	t.Helper()
+	return newTestServerWithConfig(t, db, testConfig(), logger)
+}
+
+// testConfig is the default configuration, for tests that change part of it.
+func testConfig() config.Config {
+	return config.Config{
+		MaxQueryLength: 2048,
+		QueryBudget:    10,
+	}
+}
+
+func newTestServerWithConfig(t *testing.T, db *pgxpool.Pool, cfg config.Config, logger *slog.Logger) *echo.Echo {
+	t.Helper()
+
+	flags := features.Flags{
+		"export": true,
+		"import": true,
+		"merge":  true,
+		"usage":  true,
+	}
+
+	e := echo.New()
+	configureServer(e, cfg, db, flags, logger, testAuth)
+	return e
+}
+
+// newTestDB connects to TEST_DATABASE_URL after migrating it, and skips the
+// test when it isn't set. Tests share the database, so each one should work
+// as its own user from newTestUser.
+func newTestDB(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	m, err := migrate.New("file://migrations", url)
+
+	if err != nil {
+		t.Fatalf("Unable to load migrations: %v", err)
+	}
+
+	defer m.Close()
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatalf("Unable to migrate database: %v", err)
+	}
+
+	db, err := pgxpool.New(context.Background(), url)
+
+	if err != nil {
+		t.Fatalf("Unable to connect to database: %v", err)
+	}
+
+	t.Cleanup(db.Close)
+	return db
+}
+
+// newTestUser returns a user id that owns nothing yet.
+func newTestUser() string {
+	return "test|" + uuid.NewString()
+}
+
+func request(e *echo.Echo, method, path, userID, body string) *httptest.ResponseRecorder {
+	return requestWithHeader(e, method, path, userID, body, nil)
+}
+
+// requestWithHeader is request with extra headers set on top.
+func requestWithHeader(e *echo.Echo, method, path, userID, body string, header http.Header) *httptest.ResponseRecorder {
+	var reader io.Reader
+
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set(testUserHeader, userID)
+
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect fails the test unless rec has the given status, then decodes its
+// body into a T.
+func expect[T any](t *testing.T, rec *httptest.ResponseRecorder, status int) T {
+	t.Helper()
+
+	var body T
+
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d, body %s", rec.Code, status, rec.Body)
+	}
+
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decoding body %q: %v", rec.Body, err)
+	}
+
+	return body
+}
+
+func createList(t *testing.T, e *echo.Echo, userID string) ListResponse {
+	t.Helper()
+	rec := request(e, http.MethodPost, "/list", userID, `{"title":"Groceries","description":""}`)
+	return expect[ListResponse](t, rec, http.StatusCreated)
+}
+
+func createItem(t *testing.T, e *echo.Echo, userID string, listID int64, content string) ItemResponse {
+	t.Helper()
+	rec := request(e, http.MethodPost, fmt.Sprintf("/list/%d/item", listID), userID, fmt.Sprintf(`{"content":%q}`, content))
+	return expect[ItemResponse](t, rec, http.StatusCreated)
+}
+
+func TestUnknownPathsReturnProblemNotFound(t *testing.T) {
+	e := newTestServer(t, nil)
+
+	for _, path := range []string{
+		"/",
+		"/list/5/item/3/extra",
+		"/list/5/extra/segments",
+		"/no/such/path",
+	} {
+		t.Run(path, func(t *testing.T) {
+			rec := request(e, http.MethodGet, path, "user", "")
+
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+			}
+
+			if contentType := rec.Header().Get(echo.HeaderContentType); contentType != problem.MIMEType {
+				t.Errorf("Content-Type = %q, want %q", contentType, problem.MIMEType)
+			}
+
+			var details problem.Details
+
+			if err := json.Unmarshal(rec.Body.Bytes(), &details); err != nil {
+				t.Fatalf("decoding body %q: %v", rec.Body, err)
+			}
+
+			if details.Status != http.StatusNotFound {
+				t.Errorf("body status = %d, want %d", details.Status, http.StatusNotFound)
+			}
+		})
+	}
+}
+
+func TestWhitespaceContentIsRejected(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	item := createItem(t, e, userID, list.ListID, "buy milk")
+	itemPath := fmt.Sprintf("/list/%d/item/%d", list.ListID, item.ItemID)
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPost, fmt.Sprintf("/list/%d/item", list.ListID), `{"content":"   "}`},
+		{http.MethodPut, itemPath, `{"content":"   "}`},
+		{http.MethodPatch, itemPath, `{"content":"   "}`},
+		{http.MethodPost, fmt.Sprintf("/list/%d/item/batch", list.ListID), `{"items":[{"content":"eggs"},{"content":"   "}]}`},
+	} {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			details := expect[problem.Details](t, request(e, tt.method, tt.path, userID, tt.body), http.StatusUnprocessableEntity)
+
+			if details.Detail != "content is required" {
+				t.Errorf("detail = %q, want %q", details.Detail, "content is required")
+			}
+		})
+	}
+}
+
+func TestContentIsTrimmed(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+
+	item := createItem(t, e, userID, list.ListID, "  buy milk\t")
+
+	if item.Content != "buy milk" {
+		t.Errorf("POST content = %q, want %q", item.Content, "buy milk")
+	}
+
+	itemPath := fmt.Sprintf("/list/%d/item/%d", list.ListID, item.ItemID)
+
+	item = expect[ItemResponse](t, request(e, http.MethodPut, itemPath, userID, `{"content":"  buy bread  "}`), http.StatusOK)
+
+	if item.Content != "buy bread" {
+		t.Errorf("PUT content = %q, want %q", item.Content, "buy bread")
+	}
+
+	item = expect[ItemResponse](t, request(e, http.MethodPatch, itemPath, userID, `{"content":"\n buy eggs "}`), http.StatusOK)
+
+	if item.Content != "buy eggs" {
+		t.Errorf("PATCH content = %q, want %q", item.Content, "buy eggs")
+	}
+
+	items := expect[[]ItemResponse](t, request(e, http.MethodPost, fmt.Sprintf("/list/%d/item/batch", list.ListID), userID, `{"items":[{"content":" jam "}]}`), http.StatusCreated)
+
+	if len(items) != 1 || items[0].Content != "jam" {
+		t.Errorf("batch items = %+v, want one with content %q", items, "jam")
+	}
+}
+
+func TestNewUserGetsZerosAndEmptyLists(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+
+	for _, path := range []string{"/stats", "/usage"} {
+		t.Run(path, func(t *testing.T) {
+			body := expect[map[string]any](t, request(e, http.MethodGet, path, userID, ""), http.StatusOK)
+
+			if len(body) == 0 {
+				t.Fatal("body has no members")
+			}
+
+			for name, value := range body {
+				if value != float64(0) {
+					t.Errorf("%s = %v, want 0", name, value)
+				}
+			}
+		})
+	}
+
+	for _, path := range []string{"/list?include=progress", "/items/overdue"} {
+		t.Run(path, func(t *testing.T) {
+			rec := request(e, http.MethodGet, path, userID, "")
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d, body %s", rec.Code, http.StatusOK, rec.Body)
+			}
+
+			if body := strings.TrimSpace(rec.Body.String()); body != "[]" {
+				t.Errorf("body = %s, want []", body)
+			}
+		})
+	}
+}
+
+func TestWrongMethodReturnsAllow(t *testing.T) {
+	e := newTestServer(t, nil)
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		allow  string
+	}{
+		{http.MethodGet, "/list/1/restore", "POST"},
+		{http.MethodDelete, "/list", "GET, POST"},
+		{http.MethodPost, "/healthz", "GET"},
+	} {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := request(e, tt.method, tt.path, "user", "")
+			expect[problem.Details](t, rec, http.StatusMethodNotAllowed)
+
+			if allow := rec.Header().Get(echo.HeaderAllow); allow != tt.allow {
+				t.Errorf("Allow = %q, want %q", allow, tt.allow)
+			}
+		})
+	}
+}
+
+func TestRequestLogsCarryRequestAndUserID(t *testing.T) {
+	var out bytes.Buffer
+	e := newTestServerWithLogger(t, nil, slog.New(slog.NewJSONHandler(&out, nil)))
+
+	rec := request(e, http.MethodGet, "/list/not-a-number", "user-1", "")
+	expect[problem.Details](t, rec, http.StatusBadRequest)
+
+	var record map[string]any
+
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("decoding log %q: %v", out.String(), err)
+	}
+
+	if record["request_id"] != rec.Header().Get(echo.HeaderXRequestID) {
+		t.Errorf("request_id = %v, want %q", record["request_id"], rec.Header().Get(echo.HeaderXRequestID))
+	}
+
+	if record["user_id"] != "user-1" {
+		t.Errorf("user_id = %v, want %q", record["user_id"], "user-1")
+	}
+}
+
+func TestBodiesAreDecodedStrictly(t *testing.T) {
+	e := newTestServer(t, nil)
+
+	for _, tt := range []struct {
+		path   string
+		body   string
+		detail string
+	}{
+		{"/list", `{"titel":"Groceries"}`, `Unknown field "titel"`},
+		{"/list", `{"title":"Groceries"} {"title":"Chores"}`, "Request body must contain a single JSON value"},
+		{"/list", `{"title":"Groceries"} trailing`, "Request body must contain a single JSON value"},
+		{"/list/1/item/batch", `{"items":[{"content":"eggs"},{"content":"jam","priorty":"high"}]}`, `Unknown field "items[1].priorty"`},
+	} {
+		t.Run(tt.body, func(t *testing.T) {
+			details := expect[problem.Details](t, request(e, http.MethodPost, tt.path, "user", tt.body), http.StatusBadRequest)
+
+			if details.Detail != tt.detail {
+				t.Errorf("detail = %q, want %q", details.Detail, tt.detail)
+			}
+		})
+	}
+}
+
+func TestTrashedListsDontCountTowardsUsage(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	createItem(t, e, userID, list.ListID, "buy milk")
+
+	usage := expect[UsageResponse](t, request(e, http.MethodGet, "/usage", userID, ""), http.StatusOK)
+
+	if want := int64(len("Groceries") + len("buy milk")); usage.StorageBytes != want {
+		t.Errorf("storage_bytes = %d, want %d", usage.StorageBytes, want)
+	}
+
+	listPath := fmt.Sprintf("/list/%d", list.ListID)
+
+	if rec := request(e, http.MethodDelete, listPath, userID, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	usage = expect[UsageResponse](t, request(e, http.MethodGet, "/usage", userID, ""), http.StatusOK)
+
+	if usage.StorageBytes != 0 {
+		t.Errorf("storage_bytes after delete = %d, want 0", usage.StorageBytes)
+	}
+}
+
+func TestIdempotencyKeyReplaysTheFirstResponse(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	header := http.Header{idempotency.HeaderIdempotencyKey: {uuid.NewString()}}
+	body := `{"title":"Groceries"}`
+
+	first := requestWithHeader(e, http.MethodPost, "/list", userID, body, header)
+	list := expect[ListResponse](t, first, http.StatusCreated)
+
+	second := requestWithHeader(e, http.MethodPost, "/list", userID, body, header)
+	replayed := expect[ListResponse](t, second, http.StatusCreated)
+
+	if replayed.ListID != list.ListID {
+		t.Errorf("replayed list_id = %d, want %d", replayed.ListID, list.ListID)
+	}
+
+	if second.Header().Get(idempotency.HeaderReplayed) != "true" {
+		t.Errorf("%s = %q, want %q", idempotency.HeaderReplayed, second.Header().Get(idempotency.HeaderReplayed), "true")
+	}
+
+	lists := expect[[]ListResponse](t, request(e, http.MethodGet, "/list", userID, ""), http.StatusOK)
+
+	if len(lists) != 1 {
+		t.Errorf("got %d lists, want 1", len(lists))
+	}
+
+	rec := requestWithHeader(e, http.MethodPost, "/list", userID, `{"title":"Chores"}`, header)
+	expect[problem.Details](t, rec, http.StatusUnprocessableEntity)
+
+	rec = requestWithHeader(e, http.MethodPost, fmt.Sprintf("/list/%d/item", list.ListID), userID, body, header)
+	expect[problem.Details](t, rec, http.StatusUnprocessableEntity)
+}
+
+func TestDuplicateTitlesAreUniqueAndFit(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	path := fmt.Sprintf("/list/%d/duplicate", list.ListID)
+
+	for _, want := range []string{"Groceries (copy)", "Groceries (copy 2)", "Groceries (copy 3)"} {
+		copied := expect[ListWithItemsResponse](t, request(e, http.MethodPost, path, userID, ""), http.StatusCreated)
+
+		if copied.Title != want {
+			t.Errorf("title = %q, want %q", copied.Title, want)
+		}
+	}
+
+	long := strings.Repeat("é", maxTitleLength)
+	rec := request(e, http.MethodPost, "/list", userID, fmt.Sprintf(`{"title":%q}`, long))
+	list = expect[ListResponse](t, rec, http.StatusCreated)
+
+	rec = request(e, http.MethodPost, fmt.Sprintf("/list/%d/duplicate", list.ListID), userID, "")
+	copied := expect[ListWithItemsResponse](t, rec, http.StatusCreated)
+
+	if want := strings.Repeat("é", maxTitleLength-len(" (copy)")) + " (copy)"; copied.Title != want {
+		t.Errorf("title = %q, want %q", copied.Title, want)
+	}
+}
+
+func TestImportRejectsDependencyCycles(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+
+	for name, items := range map[string]string{
+		"cycle": `[{"item_id":1,"content":"a","blocked_by_item_id":2},{"item_id":2,"content":"b","blocked_by_item_id":1}]`,
+		"self":  `[{"item_id":1,"content":"a","blocked_by_item_id":1}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			body := fmt.Sprintf(`{"lists":[{"title":"Imported","items":%s}]}`, items)
+			expect[problem.Details](t, request(e, http.MethodPost, "/import", userID, body), http.StatusUnprocessableEntity)
+		})
+	}
+
+	rec := request(e, http.MethodGet, "/list", userID, "")
+
+	if lists := expect[[]ListResponse](t, rec, http.StatusOK); len(lists) != 0 {
+		t.Errorf("got %d lists after rejected imports, want 0", len(lists))
+	}
+}
+
+func TestPositionRenumbersItems(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	first := createItem(t, e, userID, list.ListID, "first")
+	second := createItem(t, e, userID, list.ListID, "second")
+	third := createItem(t, e, userID, list.ListID, "third")
+
+	rec := request(e, http.MethodPut, fmt.Sprintf("/list/%d/item/%d/position", list.ListID, third.ItemID), userID, `{"position":1}`)
+
+	if moved := expect[ItemResponse](t, rec, http.StatusOK); moved.Position != 1 {
+		t.Errorf("moved position = %d, want 1", moved.Position)
+	}
+
+	items := expect[[]ItemResponse](t, request(e, http.MethodGet, fmt.Sprintf("/list/%d/item", list.ListID), userID, ""), http.StatusOK)
+	want := map[int64]int64{third.ItemID: 1, first.ItemID: 2, second.ItemID: 3}
+
+	for _, item := range items {
+		if item.Position != want[item.ItemID] {
+			t.Errorf("item %d position = %d, want %d", item.ItemID, item.Position, want[item.ItemID])
+		}
+	}
+}
+
+func TestListTitlesAreUniqueIgnoringCase(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+
+	rec := request(e, http.MethodPost, "/list", userID, `{"title":"GROCERIES"}`)
+
+	if details := expect[problem.Details](t, rec, http.StatusConflict); details.Detail != "You already have a list with this title" {
+		t.Errorf("detail = %q, want %q", details.Detail, "You already have a list with this title")
+	}
+
+	other := expect[ListResponse](t, request(e, http.MethodPost, "/list", userID, `{"title":"Chores"}`), http.StatusCreated)
+	rec = request(e, http.MethodPatch, fmt.Sprintf("/list/%d", other.ListID), userID, `{"title":"groceries"}`)
+	expect[problem.Details](t, rec, http.StatusConflict)
+
+	if rec := request(e, http.MethodDelete, fmt.Sprintf("/list/%d", list.ListID), userID, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	expect[ListResponse](t, request(e, http.MethodPost, "/list", userID, `{"title":"groceries"}`), http.StatusCreated)
+}
+
+func TestDeleteHonorsIfMatch(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	item := createItem(t, e, userID, list.ListID, "buy milk")
+
+	for _, tt := range []struct {
+		path    string
+		version int64
+	}{
+		{fmt.Sprintf("/list/%d/item/%d", list.ListID, item.ItemID), item.Version},
+		{fmt.Sprintf("/list/%d", list.ListID), list.Version},
+	} {
+		t.Run(tt.path, func(t *testing.T) {
+			stale := http.Header{"If-Match": {fmt.Sprintf(`"%d"`, tt.version+1)}}
+			expect[problem.Details](t, requestWithHeader(e, http.MethodDelete, tt.path, userID, "", stale), http.StatusPreconditionFailed)
+
+			current := http.Header{"If-Match": {fmt.Sprintf(`"%d"`, tt.version)}}
+
+			if rec := requestWithHeader(e, http.MethodDelete, tt.path, userID, "", current); rec.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d, body %s", rec.Code, http.StatusNoContent, rec.Body)
+			}
+
+			expect[problem.Details](t, requestWithHeader(e, http.MethodDelete, tt.path, userID, "", current), http.StatusNotFound)
+		})
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	cfg := testConfig()
+	cfg.RateLimit = 1
+	cfg.RateLimitBurst = 2
+	e := newTestServerWithConfig(t, nil, cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	for _, want := range []struct {
+		status    int
+		remaining string
+		reset     string
+	}{
+		{http.StatusBadRequest, "1", "1"},
+		{http.StatusBadRequest, "0", "2"},
+		{http.StatusTooManyRequests, "0", "2"},
+	} {
+		rec := request(e, http.MethodGet, "/list/not-a-number", "user", "")
+
+		if rec.Code != want.status {
+			t.Fatalf("status = %d, want %d", rec.Code, want.status)
+		}
+
+		for name, value := range map[string]string{
+			ratelimit.HeaderLimit:     "2",
+			ratelimit.HeaderRemaining: want.remaining,
+			ratelimit.HeaderReset:     want.reset,
+		} {
+			if got := rec.Header().Get(name); got != value {
+				t.Errorf("%s = %q, want %q", name, got, value)
+			}
+		}
+
+		if want.status == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "1" {
+			t.Errorf("Retry-After = %q, want %q", rec.Header().Get("Retry-After"), "1")
+		}
+	}
+
+	if rec := request(e, http.MethodGet, "/list/not-a-number", "other-user", ""); rec.Header().Get(ratelimit.HeaderRemaining) != "1" {
+		t.Errorf("other user's %s = %q, want %q", ratelimit.HeaderRemaining, rec.Header().Get(ratelimit.HeaderRemaining), "1")
+	}
+}
+
+func TestDuplicateTogglesAreCollapsed(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	item := createItem(t, e, userID, list.ListID, "buy milk")
+	path := fmt.Sprintf("/list/%d/item/%d/toggle", list.ListID, item.ItemID)
+
+	first := expect[ItemResponse](t, request(e, http.MethodPost, path, userID, ""), http.StatusOK)
+	second := expect[ItemResponse](t, request(e, http.MethodPost, path, userID, ""), http.StatusOK)
+
+	if !first.IsComplete || !second.IsComplete || second.Version != first.Version {
+		t.Errorf("toggles = %+v, %+v, want the same completed item twice", first, second)
+	}
+
+	time.Sleep(toggleDedupeWindow + 100*time.Millisecond)
+
+	if third := expect[ItemResponse](t, request(e, http.MethodPost, path, userID, ""), http.StatusOK); third.IsComplete {
+		t.Error("toggle after the window didn't flip the item back")
+	}
+}
+
+func TestToggleCascadeCompletesBlockedItems(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	itemsPath := fmt.Sprintf("/list/%d/item", list.ListID)
+
+	first := createItem(t, e, userID, list.ListID, "first")
+	second := expect[ItemResponse](t, request(e, http.MethodPost, itemsPath, userID, fmt.Sprintf(`{"content":"second","blocked_by_item_id":%d}`, first.ItemID)), http.StatusCreated)
+	third := expect[ItemResponse](t, request(e, http.MethodPost, itemsPath, userID, fmt.Sprintf(`{"content":"third","blocked_by_item_id":%d}`, second.ItemID)), http.StatusCreated)
+	unrelated := createItem(t, e, userID, list.ListID, "unrelated")
+
+	rec := request(e, http.MethodPost, fmt.Sprintf("%s/%d/toggle?cascade=true", itemsPath, first.ItemID), userID, "")
+	body := expect[ToggleCascadeResponse](t, rec, http.StatusOK)
+
+	if want := []int64{first.ItemID, second.ItemID, third.ItemID}; !slices.Equal(body.AffectedItemIDs, want) {
+		t.Errorf("affected_item_ids = %v, want %v", body.AffectedItemIDs, want)
+	}
+
+	for _, item := range expect[[]ItemResponse](t, request(e, http.MethodGet, itemsPath, userID, ""), http.StatusOK) {
+		if want := item.ItemID != unrelated.ItemID; item.IsComplete != want {
+			t.Errorf("item %q is_complete = %t, want %t", item.Content, item.IsComplete, want)
+		}
+	}
+}
+
+func TestSearchPagination(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+
+	for _, content := range []string{"grocery run", "grocery budget", "grocery bags"} {
+		createItem(t, e, userID, list.ListID, content)
+	}
+
+	createItem(t, e, userID, list.ListID, "walk the dog")
+
+	rec := request(e, http.MethodGet, "/search?q=groc&limit=2", userID, "")
+	page := expect[SearchPageResponse](t, rec, http.StatusOK)
+
+	if total := rec.Header().Get("X-Total-Count"); total != "4" {
+		t.Errorf("X-Total-Count = %q, want %q", total, "4")
+	}
+
+	if len(page.Lists) != 1 || page.NextListsCursor != nil {
+		t.Errorf("lists = %d, next_lists_cursor = %v, want 1 and null", len(page.Lists), page.NextListsCursor)
+	}
+
+	if len(page.Items) != 2 || page.NextItemsCursor == nil {
+		t.Fatalf("items = %d, next_items_cursor = %v, want 2 and a cursor", len(page.Items), page.NextItemsCursor)
+	}
+
+	rec = request(e, http.MethodGet, fmt.Sprintf("/search?q=groc&limit=2&lists_after=%d&items_after=%d", list.ListID, *page.NextItemsCursor), userID, "")
+	page = expect[SearchPageResponse](t, rec, http.StatusOK)
+
+	if len(page.Lists) != 0 || len(page.Items) != 1 || page.NextItemsCursor != nil {
+		t.Errorf("second page = %d lists, %d items, next_items_cursor %v, want 0, 1 and null", len(page.Lists), len(page.Items), page.NextItemsCursor)
+	}
+
+	if total := rec.Header().Get("X-Total-Count"); total != "4" {
+		t.Errorf("second page X-Total-Count = %q, want %q", total, "4")
+	}
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	cfg := testConfig()
+	cfg.ListCacheSize = 10
+	e := newTestServerWithConfig(t, newTestDB(t), cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	item := createItem(t, e, userID, list.ListID, "buy milk")
+
+	for _, path := range []string{
+		fmt.Sprintf("/list/%d", list.ListID),
+		fmt.Sprintf("/list/%d/item/%d", list.ListID, item.ItemID),
+	} {
+		t.Run(path, func(t *testing.T) {
+			rec := request(e, http.MethodGet, path, userID, "")
+			lastModified := rec.Header().Get(echo.HeaderLastModified)
+
+			if rec.Code != http.StatusOK || lastModified == "" {
+				t.Fatalf("status = %d, Last-Modified = %q, want 200 and a date", rec.Code, lastModified)
+			}
+
+			// The list is answered from the cache the first GET filled.
+			rec = requestWithHeader(e, http.MethodGet, path, userID, "", http.Header{echo.HeaderIfModifiedSince: {lastModified}})
+
+			if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+				t.Errorf("status = %d, body %q, want 304 and no body", rec.Code, rec.Body)
+			}
+
+			modified, _ := http.ParseTime(lastModified)
+			earlier := modified.Add(-time.Hour).Format(http.TimeFormat)
+			rec = requestWithHeader(e, http.MethodGet, path, userID, "", http.Header{echo.HeaderIfModifiedSince: {earlier}})
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+// requireFields fails the test unless each field is in body and isn't null,
+// zero or empty.
+func requireFields(t *testing.T, name string, body map[string]any, fields ...string) {
+	t.Helper()
+
+	for _, field := range fields {
+		if value, ok := body[field]; !ok || value == nil || value == float64(0) || value == "" {
+			t.Errorf("%s: %s = %v, want it set", name, field, value)
+		}
+	}
+}
+
+func TestWritesReturnServerComputedFields(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	other := expect[ListResponse](t, request(e, http.MethodPost, "/list", userID, `{"title":"Chores"}`), http.StatusCreated)
+	item := createItem(t, e, userID, list.ListID, "buy milk")
+	spare := createItem(t, e, userID, other.ListID, "sweep")
+
+	listPath := fmt.Sprintf("/list/%d", list.ListID)
+	itemPath := fmt.Sprintf("%s/item/%d", listPath, item.ItemID)
+	listFields := []string{"created_at", "updated_at", "version"}
+	itemFields := []string{"created_at", "updated_at", "position", "version"}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, "/list", `{"title":"Errands"}`, http.StatusCreated},
+		{http.MethodPut, listPath, `{"title":"Groceries"}`, http.StatusOK},
+		{http.MethodPatch, listPath, `{"description":"weekly"}`, http.StatusOK},
+	} {
+		rec := request(e, tt.method, tt.path, userID, tt.body)
+		requireFields(t, tt.method+" "+tt.path, expect[map[string]any](t, rec, tt.status), listFields...)
+	}
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{http.MethodPost, listPath + "/item", `{"content":"eggs"}`, http.StatusCreated},
+		{http.MethodPut, itemPath, `{"content":"buy bread"}`, http.StatusOK},
+		{http.MethodPatch, itemPath, `{"priority":"high"}`, http.StatusOK},
+		{http.MethodPut, itemPath + "/position", `{"position":1}`, http.StatusOK},
+		{http.MethodPost, itemPath + "/toggle", "", http.StatusOK},
+		{http.MethodPost, fmt.Sprintf("/list/%d/item/%d/move", other.ListID, spare.ItemID), fmt.Sprintf(`{"target_list_id":%d}`, list.ListID), http.StatusOK},
+	} {
+		rec := request(e, tt.method, tt.path, userID, tt.body)
+		requireFields(t, tt.method+" "+tt.path, expect[map[string]any](t, rec, tt.status), itemFields...)
+	}
+
+	rec := request(e, http.MethodPost, listPath+"/item/batch", userID, `{"items":[{"content":"jam"},{"content":"tea"}]}`)
+
+	for _, body := range expect[[]map[string]any](t, rec, http.StatusCreated) {
+		requireFields(t, "POST batch", body, itemFields...)
+	}
+
+	for _, tt := range []struct {
+		path string
+		body string
+	}{
+		{listPath + "/duplicate", ""},
+		{listPath + "/merge", fmt.Sprintf(`{"source_list_id":%d}`, other.ListID)},
+	} {
+		var body struct {
+			ListResponse
+			Items []map[string]any `json:"items"`
+		}
+
+		rec := request(e, http.MethodPost, tt.path, userID, tt.body)
+
+		if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+			t.Fatalf("POST %s status = %d, body %s", tt.path, rec.Code, rec.Body)
+		}
+
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decoding body %q: %v", rec.Body, err)
+		}
+
+		if body.Version == 0 || body.CreatedAt.IsZero() || body.UpdatedAt.IsZero() {
+			t.Errorf("POST %s: list = %+v, want version and timestamps set", tt.path, body.ListResponse)
+		}
+
+		for _, item := range body.Items {
+			requireFields(t, "POST "+tt.path, item, itemFields...)
+		}
+	}
+
+	if rec := request(e, http.MethodDelete, listPath, userID, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	rec = request(e, http.MethodPost, listPath+"/restore", userID, "")
+	requireFields(t, "POST restore", expect[map[string]any](t, rec, http.StatusOK), listFields...)
+}
+
+func TestCompletingTwiceKeepsCompletedAt(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	item := createItem(t, e, userID, list.ListID, "buy milk")
+	itemPath := fmt.Sprintf("/list/%d/item/%d", list.ListID, item.ItemID)
+
+	first := expect[ItemResponse](t, request(e, http.MethodPatch, itemPath, userID, `{"is_complete":true}`), http.StatusOK)
+
+	if first.CompletedAt == nil {
+		t.Fatal("completed_at is null after completing the item")
+	}
+
+	// NOW() is the transaction start, so later writes would get a later time.
+	time.Sleep(10 * time.Millisecond)
+
+	for _, tt := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPatch, itemPath, `{"is_complete":true}`},
+		{http.MethodPut, itemPath, `{"content":"buy milk","is_complete":true}`},
+		{http.MethodPost, fmt.Sprintf("/list/%d/complete-all", list.ListID), ""},
+	} {
+		if rec := request(e, tt.method, tt.path, userID, tt.body); rec.Code != http.StatusOK {
+			t.Fatalf("%s %s status = %d, body %s", tt.method, tt.path, rec.Code, rec.Body)
+		}
+
+		again := expect[ItemResponse](t, request(e, http.MethodGet, itemPath, userID, ""), http.StatusOK)
+
+		if again.CompletedAt == nil || !again.CompletedAt.Equal(*first.CompletedAt) {
+			t.Errorf("after %s %s completed_at = %v, want %v", tt.method, tt.path, again.CompletedAt, *first.CompletedAt)
+		}
+	}
+}
+
+func TestMergeMovesItemsToTheEnd(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	target := createList(t, e, userID)
+	source := expect[ListResponse](t, request(e, http.MethodPost, "/list", userID, `{"title":"Chores"}`), http.StatusCreated)
+
+	createItem(t, e, userID, target.ListID, "buy milk")
+	createItem(t, e, userID, target.ListID, "buy eggs")
+	createItem(t, e, userID, source.ListID, "sweep")
+	createItem(t, e, userID, source.ListID, "mop")
+
+	mergePath := fmt.Sprintf("/list/%d/merge", target.ListID)
+
+	for _, tt := range []struct {
+		body   string
+		status int
+	}{
+		{fmt.Sprintf(`{"source_list_id":%d}`, target.ListID), http.StatusUnprocessableEntity},
+		{`{"source_list_id":999999999}`, http.StatusNotFound},
+	} {
+		if rec := request(e, http.MethodPost, mergePath, userID, tt.body); rec.Code != tt.status {
+			t.Errorf("merge %s status = %d, want %d", tt.body, rec.Code, tt.status)
+		}
+	}
+
+	rec := request(e, http.MethodPost, mergePath, userID, fmt.Sprintf(`{"source_list_id":%d,"delete_source":true}`, source.ListID))
+	merged := expect[ListWithItemsResponse](t, rec, http.StatusOK)
+
+	var contents []string
+
+	for n, item := range merged.Items {
+		contents = append(contents, item.Content)
+
+		if item.Position != int64(n+1) {
+			t.Errorf("item %q position = %d, want %d", item.Content, item.Position, n+1)
+		}
+	}
+
+	if want := []string{"buy milk", "buy eggs", "sweep", "mop"}; !slices.Equal(contents, want) {
+		t.Errorf("items = %v, want %v", contents, want)
+	}
+
+	expect[problem.Details](t, request(e, http.MethodGet, fmt.Sprintf("/list/%d", source.ListID), userID, ""), http.StatusNotFound)
+
+	// Someone else can't merge into or out of this user's lists.
+	rec = request(e, http.MethodPost, mergePath, newTestUser(), fmt.Sprintf(`{"source_list_id":%d}`, source.ListID))
+	expect[problem.Details](t, rec, http.StatusNotFound)
+}
+
+func TestExportSelectedLists(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	skipped := expect[ListResponse](t, request(e, http.MethodPost, "/list", userID, `{"title":"Chores"}`), http.StatusCreated)
+	createItem(t, e, userID, list.ListID, "buy milk")
+	createItem(t, e, userID, skipped.ListID, "sweep")
+
+	rec := request(e, http.MethodGet, fmt.Sprintf("/export?list_ids=%d", list.ListID), userID, "")
+	export := expect[ExportResponse](t, rec, http.StatusOK)
+
+	if len(export.Lists) != 1 || export.Lists[0].ListID != list.ListID {
+		t.Fatalf("exported lists = %+v, want only list %d", export.Lists, list.ListID)
+	}
+
+	if items := export.Lists[0].Items; len(items) != 1 || items[0].Content != "buy milk" {
+		t.Errorf("exported items = %+v, want one with content %q", items, "buy milk")
+	}
+
+	// Another user's lists are reported as missing.
+	rec = request(e, http.MethodGet, fmt.Sprintf("/export?list_ids=%d,%d", list.ListID, skipped.ListID), newTestUser(), "")
+	expect[problem.Details](t, rec, http.StatusNotFound)
+}
+
+func TestImportRoundTrip(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	blocker := createItem(t, e, userID, list.ListID, "buy milk")
+	rec := request(e, http.MethodPost, fmt.Sprintf("/list/%d/item", list.ListID), userID, fmt.Sprintf(`{"content":"make tea","blocked_by_item_id":%d}`, blocker.ItemID))
+	expect[ItemResponse](t, rec, http.StatusCreated)
+
+	rec = request(e, http.MethodGet, fmt.Sprintf("/export?list_ids=%d", list.ListID), userID, "")
+	export := rec.Body.String()
+
+	importer := newTestUser()
+	expect[ListResponse](t, request(e, http.MethodPost, "/list", importer, `{"title":"Chores"}`), http.StatusCreated)
+
+	imported := expect[ImportResponse](t, request(e, http.MethodPost, "/import", importer, export), http.StatusCreated)
+
+	if imported.Lists != 1 || imported.Items != 2 {
+		t.Errorf("imported = %+v, want 1 list and 2 items", imported)
+	}
+
+	lists := expect[[]ListResponse](t, request(e, http.MethodGet, "/list", importer, ""), http.StatusOK)
+
+	if len(lists) != 2 {
+		t.Fatalf("got %d lists after merge import, want 2", len(lists))
+	}
+
+	rec = request(e, http.MethodPost, "/import?mode=replace", importer, export)
+	expect[ImportResponse](t, rec, http.StatusCreated)
+
+	lists = expect[[]ListResponse](t, request(e, http.MethodGet, "/list", importer, ""), http.StatusOK)
+
+	if len(lists) != 1 || lists[0].Title != "Groceries" {
+		t.Fatalf("lists after replace import = %+v, want only Groceries", lists)
+	}
+
+	items := expect[[]ItemResponse](t, request(e, http.MethodGet, fmt.Sprintf("/list/%d/item", lists[0].ListID), importer, ""), http.StatusOK)
+
+	if len(items) != 2 || items[1].BlockedByItemID == nil || *items[1].BlockedByItemID != items[0].ItemID {
+		t.Errorf("imported items = %+v, want the second blocked by the first", items)
+	}
+
+	expect[problem.Details](t, request(e, http.MethodPost, "/import?mode=upsert", importer, export), http.StatusBadRequest)
+}
+
+func TestUpdatesHonorIfMatch(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	item := createItem(t, e, userID, list.ListID, "buy milk")
+	listPath := fmt.Sprintf("/list/%d", list.ListID)
+	itemPath := fmt.Sprintf("%s/item/%d", listPath, item.ItemID)
+
+	for _, tt := range []struct {
+		method  string
+		path    string
+		body    string
+		version int64
+	}{
+		{http.MethodPut, listPath, `{"title":"Groceries"}`, list.Version},
+		{http.MethodPatch, listPath, `{"description":"weekly"}`, list.Version + 1},
+		{http.MethodPut, itemPath, `{"content":"buy bread"}`, item.Version},
+		{http.MethodPatch, itemPath, `{"priority":"high"}`, item.Version + 1},
+	} {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			ifMatch := func(version string) http.Header {
+				return http.Header{"If-Match": {version}}
+			}
+
+			rec := requestWithHeader(e, tt.method, tt.path, userID, tt.body, ifMatch(`"abc"`))
+			expect[problem.Details](t, rec, http.StatusBadRequest)
+
+			rec = requestWithHeader(e, tt.method, tt.path, userID, tt.body, ifMatch(fmt.Sprintf(`"%d"`, tt.version-1)))
+			expect[problem.Details](t, rec, http.StatusPreconditionFailed)
+
+			rec = requestWithHeader(e, tt.method, tt.path, userID, tt.body, ifMatch(fmt.Sprintf(`"%d"`, tt.version)))
+
+			if body := expect[map[string]any](t, rec, http.StatusOK); body["version"] != float64(tt.version+1) {
+				t.Errorf("version = %v, want %d", body["version"], tt.version+1)
+			}
+		})
+	}
+
+	rec := requestWithHeader(e, http.MethodPatch, fmt.Sprintf("/list/%d", list.ListID+1000000), userID, `{"title":"x"}`, http.Header{"If-Match": {`"1"`}})
+	expect[problem.Details](t, rec, http.StatusNotFound)
+}
+
+func TestItemCursorPagination(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	itemsPath := fmt.Sprintf("/list/%d/item", list.ListID)
+
+	var want []int64
+
+	for n := range 5 {
+		want = append(want, createItem(t, e, userID, list.ListID, fmt.Sprintf("item %d", n)).ItemID)
+	}
+
+	var got []int64
+	path := itemsPath + "?limit=2"
+
+	for pages := 0; ; pages++ {
+		if pages == 3 {
+			t.Fatal("more than 3 pages of 2 for 5 items")
+		}
+
+		page := expect[ItemPageResponse](t, request(e, http.MethodGet, path, userID, ""), http.StatusOK)
+
+		for _, item := range page.Items {
+			got = append(got, item.ItemID)
+		}
+
+		if page.NextCursor == nil {
+			break
+		}
+
+		path = fmt.Sprintf("%s?limit=2&after=%d", itemsPath, *page.NextCursor)
+	}
+
+	if !slices.Equal(got, want) {
+		t.Errorf("paged item ids = %v, want %v", got, want)
+	}
+
+	for _, query := range []string{"?limit=0", fmt.Sprintf("?limit=%d", maxListLimit+1), "?limit=2&sort=content"} {
+		expect[problem.Details](t, request(e, http.MethodGet, itemsPath+query, userID, ""), http.StatusBadRequest)
+	}
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	e := newTestServer(t, newTestDB(t))
+	userID := newTestUser()
+	list := createList(t, e, userID)
+	createItem(t, e, userID, list.ListID, "buy milk")
+	listPath := fmt.Sprintf("/list/%d", list.ListID)
+
+	expect[problem.Details](t, request(e, http.MethodPost, listPath+"/restore", userID, ""), http.StatusNotFound)
+
+	if rec := request(e, http.MethodDelete, listPath, userID, ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	for _, path := range []string{listPath, listPath + "/item"} {
+		expect[problem.Details](t, request(e, http.MethodGet, path, userID, ""), http.StatusNotFound)
+	}
+
+	if lists := expect[[]ListResponse](t, request(e, http.MethodGet, "/list", userID, ""), http.StatusOK); len(lists) != 0 {
+		t.Errorf("GET /list returned %d lists, want the deleted one left out", len(lists))
+	}
+
+	// Only the owner can restore it.
+	expect[problem.Details](t, request(e, http.MethodPost, listPath+"/restore", newTestUser(), ""), http.StatusNotFound)
+
+	restored := expect[ListResponse](t, request(e, http.MethodPost, listPath+"/restore", userID, ""), http.StatusOK)
+
+	if restored.ListID != list.ListID || restored.Version != list.Version+1 {
+		t.Errorf("restored = %+v, want list %d at version %d", restored, list.ListID, list.Version+1)
+	}
+
+	items := expect[[]ItemResponse](t, request(e, http.MethodGet, listPath+"/item", userID, ""), http.StatusOK)
+
+	if len(items) != 1 || items[0].Content != "buy milk" {
+		t.Errorf("items after restore = %+v, want buy milk back", items)
+	}
+}
@@ -0,0 +1 @@
+ALTER TABLE "todo"."items" DROP COLUMN IF EXISTS "blocked_by_item_id";
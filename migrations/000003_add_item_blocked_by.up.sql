@@ -0,0 +1,3 @@
+ALTER TABLE "todo"."items"
+    ADD COLUMN IF NOT EXISTS "blocked_by_item_id" BIGINT NULL
+    REFERENCES "todo"."items" ("item_id") ON DELETE SET NULL;
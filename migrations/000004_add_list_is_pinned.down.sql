@@ -0,0 +1 @@
+ALTER TABLE "todo"."lists" DROP COLUMN IF EXISTS "is_pinned";
@@ -0,0 +1 @@
+ALTER TABLE "todo"."lists" ADD COLUMN IF NOT EXISTS "is_pinned" bool NOT NULL DEFAULT false;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "todo"."lists_user_id_category_index";
+ALTER TABLE "todo"."lists" DROP COLUMN IF EXISTS "category";
@@ -0,0 +1,5 @@
+ALTER TABLE "todo"."lists"
+    ADD COLUMN IF NOT EXISTS "category" text NOT NULL DEFAULT 'other'
+    CHECK ("category" IN ('personal', 'work', 'shopping', 'other'));
+
+CREATE INDEX IF NOT EXISTS "lists_user_id_category_index" ON "todo"."lists" ("user_id", "category");
@@ -0,0 +1,7 @@
+ALTER TABLE "todo"."items"
+    DROP COLUMN IF EXISTS "updated_at",
+    DROP COLUMN IF EXISTS "created_at";
+
+ALTER TABLE "todo"."lists"
+    DROP COLUMN IF EXISTS "updated_at",
+    DROP COLUMN IF EXISTS "created_at";
@@ -0,0 +1,7 @@
+ALTER TABLE "todo"."lists"
+    ADD COLUMN IF NOT EXISTS "created_at" timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS "updated_at" timestamptz NOT NULL DEFAULT now();
+
+ALTER TABLE "todo"."items"
+    ADD COLUMN IF NOT EXISTS "created_at" timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS "updated_at" timestamptz NOT NULL DEFAULT now();
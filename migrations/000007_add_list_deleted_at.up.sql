@@ -0,0 +1 @@
+ALTER TABLE "todo"."lists" ADD COLUMN IF NOT EXISTS "deleted_at" timestamptz NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "todo"."items_list_id_position_index";
+ALTER TABLE "todo"."items" DROP COLUMN IF EXISTS "position";
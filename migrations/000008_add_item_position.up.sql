@@ -0,0 +1,11 @@
+ALTER TABLE "todo"."items" ADD COLUMN IF NOT EXISTS "position" integer NOT NULL DEFAULT 0;
+
+UPDATE "todo"."items"
+SET "position" = "numbered"."position"
+FROM (
+    SELECT "item_id", row_number() OVER (PARTITION BY "list_id" ORDER BY "item_id") AS "position"
+    FROM "todo"."items"
+) AS "numbered"
+WHERE "items"."item_id" = "numbered"."item_id";
+
+CREATE INDEX IF NOT EXISTS "items_list_id_position_index" ON "todo"."items" ("list_id", "position");
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "todo"."items_due_date_index";
+ALTER TABLE "todo"."items" DROP COLUMN IF EXISTS "due_date";
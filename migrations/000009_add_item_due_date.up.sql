@@ -0,0 +1,3 @@
+ALTER TABLE "todo"."items" ADD COLUMN IF NOT EXISTS "due_date" timestamptz NULL;
+
+CREATE INDEX IF NOT EXISTS "items_due_date_index" ON "todo"."items" ("due_date") WHERE "due_date" IS NOT NULL;
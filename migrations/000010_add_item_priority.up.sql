@@ -0,0 +1,3 @@
+ALTER TABLE "todo"."items"
+    ADD COLUMN IF NOT EXISTS "priority" text NOT NULL DEFAULT 'medium'
+    CHECK ("priority" IN ('low', 'medium', 'high'));
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS "todo"."lists_user_id_title_index";
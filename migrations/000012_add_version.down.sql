@@ -0,0 +1,3 @@
+ALTER TABLE "todo"."items" DROP COLUMN IF EXISTS "version";
+
+ALTER TABLE "todo"."lists" DROP COLUMN IF EXISTS "version";
@@ -0,0 +1,3 @@
+ALTER TABLE "todo"."lists" ADD COLUMN IF NOT EXISTS "version" bigint NOT NULL DEFAULT 1;
+
+ALTER TABLE "todo"."items" ADD COLUMN IF NOT EXISTS "version" bigint NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+ALTER TABLE "todo"."items" DROP COLUMN IF EXISTS "completed_at";
@@ -0,0 +1,5 @@
+ALTER TABLE "todo"."items" ADD COLUMN IF NOT EXISTS "completed_at" timestamptz NULL;
+
+-- The real completion time of existing items isn't known, the last update is
+-- the closest there is.
+UPDATE "todo"."items" SET "completed_at" = "updated_at" WHERE "is_complete" AND "completed_at" IS NULL;
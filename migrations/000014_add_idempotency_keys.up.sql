@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS "todo"."idempotency_keys" (
+    "user_id" text NOT NULL,
+    "key" text NOT NULL,
+    "method" text NOT NULL,
+    "path" text NOT NULL,
+    -- NULL while the request that claimed the key is still running.
+    "status" integer NULL,
+    "content_type" text NULL,
+    "body" text NULL,
+    "created_at" timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY ("user_id", "key")
+);
+
+CREATE INDEX IF NOT EXISTS "idempotency_keys_created_at_index" ON "todo"."idempotency_keys" ("created_at");
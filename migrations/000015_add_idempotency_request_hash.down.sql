@@ -0,0 +1 @@
+ALTER TABLE "todo"."idempotency_keys" DROP COLUMN IF EXISTS "request_hash";
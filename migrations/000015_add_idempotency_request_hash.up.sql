@@ -0,0 +1,2 @@
+-- NULL for keys claimed before the hash was recorded.
+ALTER TABLE "todo"."idempotency_keys" ADD COLUMN IF NOT EXISTS "request_hash" text NULL;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS "todo"."lists_user_id_lower_title_index";
+CREATE UNIQUE INDEX IF NOT EXISTS "lists_user_id_title_index" ON "todo"."lists" ("user_id", "title") WHERE "deleted_at" IS NULL;
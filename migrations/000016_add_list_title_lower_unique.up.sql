@@ -0,0 +1,13 @@
+-- Titles that only differ in case now clash. The first list keeps its title,
+-- the rest get their id appended so the index can be built.
+UPDATE "todo"."lists" AS "l"
+SET "title" = "l"."title" || ' (' || "l"."list_id" || ')'
+FROM (
+    SELECT "list_id", row_number() OVER (PARTITION BY "user_id", LOWER("title") ORDER BY "list_id") AS "n"
+    FROM "todo"."lists"
+    WHERE "deleted_at" IS NULL
+) AS "d"
+WHERE "d"."list_id" = "l"."list_id" AND "d"."n" > 1;
+
+DROP INDEX IF EXISTS "todo"."lists_user_id_title_index";
+CREATE UNIQUE INDEX IF NOT EXISTS "lists_user_id_lower_title_index" ON "todo"."lists" ("user_id", LOWER("title")) WHERE "deleted_at" IS NULL;